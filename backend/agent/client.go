@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mudit06mah/CloudIde/workspace"
+)
+
+const requestTimeout = 30 * time.Second
+
+// Client is a workspace.FileService backed by a workspace's file agent
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+// NewClient talks to the agent listening on host (usually the pod IP) at DefaultPort
+func NewClient(host string, token string) *Client {
+	return &Client{
+		BaseURL: fmt.Sprintf("http://%s:%d", host, DefaultPort),
+		Token:   token,
+		HTTP:    &http.Client{},
+	}
+}
+
+// do sends a request and turns error statuses back into file errors; the caller closes the body
+func (c *Client) do(ctx context.Context, method string, endpoint string, query url.Values, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+endpoint+"?"+query.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("file agent unreachable: %v", err)
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	p := query.Get("path")
+	if p == "" {
+		p = query.Get("from")
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, &fs.PathError{Op: method, Path: p, Err: fs.ErrNotExist}
+	case http.StatusForbidden:
+		return nil, &workspace.PathError{Path: p}
+	default:
+		return nil, fmt.Errorf("file agent: %s", strings.TrimSpace(string(msg)))
+	}
+}
+
+// call is do for requests whose response body is not needed
+func (c *Client) call(ctx context.Context, method string, endpoint string, query url.Values, body io.Reader) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	resp, err := c.do(ctx, method, endpoint, query, body)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *Client) Create(ctx context.Context, clientPath string) error {
+	return c.WriteFile(ctx, clientPath, nil)
+}
+
+func (c *Client) ReadFile(ctx context.Context, clientPath string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	resp, err := c.do(ctx, http.MethodGet, "/v1/files", url.Values{"path": {clientPath}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (c *Client) WriteFile(ctx context.Context, clientPath string, data []byte) error {
+	return c.call(ctx, http.MethodPut, "/v1/files", url.Values{"path": {clientPath}}, bytes.NewReader(data))
+}
+
+func (c *Client) Remove(ctx context.Context, clientPath string) error {
+	return c.call(ctx, http.MethodDelete, "/v1/files", url.Values{"path": {clientPath}}, nil)
+}
+
+func (c *Client) MkdirAll(ctx context.Context, clientPath string) error {
+	return c.call(ctx, http.MethodPost, "/v1/dirs", url.Values{"path": {clientPath}}, nil)
+}
+
+func (c *Client) RemoveAll(ctx context.Context, clientPath string) error {
+	return c.call(ctx, http.MethodDelete, "/v1/files", url.Values{"path": {clientPath}, "recursive": {"true"}}, nil)
+}
+
+func (c *Client) ReadDir(ctx context.Context, clientPath string) ([]workspace.Entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	resp, err := c.do(ctx, http.MethodGet, "/v1/dirs", url.Values{"path": {clientPath}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var entries []workspace.Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode listing: %v", err)
+	}
+	return entries, nil
+}
+
+func (c *Client) Rename(ctx context.Context, from string, to string) error {
+	return c.call(ctx, http.MethodPost, "/v1/rename", url.Values{"from": {from}, "to": {to}}, nil)
+}
+
+// Watch streams the agent's change events until ctx is done or the connection drops
+func (c *Client) Watch(ctx context.Context, clientPath string) (<-chan workspace.Event, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/v1/watch", url.Values{"path": {clientPath}}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan workspace.Event)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var ev workspace.Event
+			if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+				continue
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,180 @@
+// Package agent is the file agent running as a sidecar in workspace pods, and the
+// backend's client for it. Both sides speak a small HTTP API below /v1:
+//
+//	GET    /v1/files?path=       read a file
+//	PUT    /v1/files?path=       write the request body to a file
+//	DELETE /v1/files?path=       remove a file or empty folder (&recursive=true for a whole tree)
+//	GET    /v1/dirs?path=        list a folder as JSON
+//	POST   /v1/dirs?path=        create a folder and its parents
+//	POST   /v1/rename?from=&to=  move a file or folder
+//	GET    /v1/watch?path=       stream changes as newline delimited JSON events
+package agent
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/mudit06mah/CloudIde/workspace"
+)
+
+// DefaultPort is where the agent listens inside the workspace pod
+const DefaultPort = 7070
+
+// maxFileSize bounds a single upload
+const maxFileSize = 64 << 20
+
+// Server exposes FS over HTTP. With a Token, every request but /healthz needs it as a bearer token.
+type Server struct {
+	FS    *workspace.FS
+	Token string
+}
+
+func NewServer(root string, token string) *Server {
+	return &Server{FS: &workspace.FS{Root: root}, Token: token}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v1/files", s.authorized(s.handleFiles))
+	mux.HandleFunc("/v1/dirs", s.authorized(s.handleDirs))
+	mux.HandleFunc("/v1/rename", s.authorized(s.handleRename))
+	mux.HandleFunc("/v1/watch", s.authorized(s.handleWatch))
+	return mux
+}
+
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+
+	switch r.Method {
+	case http.MethodGet:
+		data, err := s.FS.ReadFile(r.Context(), p)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	case http.MethodPut:
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFileSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := s.FS.WriteFile(r.Context(), p, data); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		var err error
+		if r.URL.Query().Get("recursive") == "true" {
+			err = s.FS.RemoveAll(r.Context(), p)
+		} else {
+			err = s.FS.Remove(r.Context(), p)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleDirs(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := s.FS.ReadDir(r.Context(), p)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	case http.MethodPost:
+		if err := s.FS.MkdirAll(r.Context(), p); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	if err := s.FS.Rename(r.Context(), query.Get("from"), query.Get("to")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events, err := s.FS.Watch(r.Context(), r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeError maps file errors to status codes the client turns back into the same errors
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, workspace.ErrOutsideWorkspace):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case os.IsNotExist(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Println("File agent error:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
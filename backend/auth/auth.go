@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Identity is the authenticated caller attached to a session
+type Identity struct {
+	UserID string
+	// Plans are the resource profiles the user may create workspaces with
+	Plans []string
+}
+
+// Allows reports whether the user may use plan
+func (i *Identity) Allows(plan string) bool {
+	for _, p := range i.Plans {
+		if p == plan {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator validates a request before the websocket upgrade.
+// HMAC tokens are the only real implementation for now; JWT/OIDC can slot in later.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// NewFromEnv picks an authenticator based on AUTH_MODE (hmac by default).
+// Users whose token names no plans get DEFAULT_PLANS (comma separated, "small" if unset).
+func NewFromEnv() (Authenticator, error) {
+	defaultPlans := []string{"small"}
+	if env := os.Getenv("DEFAULT_PLANS"); env != "" {
+		defaultPlans = nil
+		for _, plan := range strings.Split(env, ",") {
+			if plan = strings.TrimSpace(plan); plan != "" {
+				defaultPlans = append(defaultPlans, plan)
+			}
+		}
+	}
+
+	mode := os.Getenv("AUTH_MODE")
+	switch mode {
+	case "", "hmac":
+		secret := os.Getenv("AUTH_SECRET")
+		if secret == "" {
+			return nil, fmt.Errorf("AUTH_SECRET must be set for hmac auth")
+		}
+		authenticator := NewHMACAuthenticator([]byte(secret))
+		authenticator.DefaultPlans = defaultPlans
+		return authenticator, nil
+	case "none":
+		log.Println("WARNING: authentication disabled (AUTH_MODE=none)")
+		return &anonymousAuthenticator{plans: defaultPlans}, nil
+	default:
+		return nil, fmt.Errorf("unsupported AUTH_MODE: %s", mode)
+	}
+}
+
+// bearerToken reads the token from the Authorization header, falling back to
+// the "token" query param since browsers can't set headers on websockets.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("token")
+}
+
+// anonymousAuthenticator accepts everyone as the same local user, for development only
+type anonymousAuthenticator struct {
+	plans []string
+}
+
+func (a *anonymousAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	return &Identity{UserID: "anonymous", Plans: a.plans}, nil
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type claims struct {
+	Subject   string   `json:"sub"`
+	ExpiresAt int64    `json:"exp"`
+	Plans     []string `json:"plans,omitempty"`
+}
+
+// HMACAuthenticator verifies tokens of the form base64url(claims).base64url(hmac-sha256)
+type HMACAuthenticator struct {
+	// DefaultPlans apply to tokens that don't list any plans
+	DefaultPlans []string
+
+	secret []byte
+	now    func() time.Time
+}
+
+func NewHMACAuthenticator(secret []byte) *HMACAuthenticator {
+	return &HMACAuthenticator{secret: secret, now: time.Now}
+}
+
+// Sign issues a token for userId valid for ttl, optionally granting plans
+func (a *HMACAuthenticator) Sign(userId string, ttl time.Duration, plans ...string) (string, error) {
+	body, err := json.Marshal(claims{Subject: userId, ExpiresAt: a.now().Add(ttl).Unix(), Plans: plans})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(body)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(a.mac(payload)), nil
+}
+
+func (a *HMACAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, fmt.Errorf("%w: missing token", ErrUnauthenticated)
+	}
+
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+
+	gotSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(gotSig, a.mac(payload)) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrUnauthenticated)
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	var c claims
+	if err := json.Unmarshal(body, &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrUnauthenticated)
+	}
+	if c.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrUnauthenticated)
+	}
+	if a.now().Unix() >= c.ExpiresAt {
+		return nil, fmt.Errorf("%w: token expired", ErrUnauthenticated)
+	}
+
+	plans := c.Plans
+	if len(plans) == 0 {
+		plans = a.DefaultPlans
+	}
+	return &Identity{UserID: c.Subject, Plans: plans}, nil
+}
+
+func (a *HMACAuthenticator) mac(payload string) []byte {
+	m := hmac.New(sha256.New, a.secret)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
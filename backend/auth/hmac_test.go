@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// authenticate passes token the way the frontend does, in the query string
+func authenticate(a Authenticator, token string) (*Identity, error) {
+	r := httptest.NewRequest("GET", "/ws?token="+url.QueryEscape(token), nil)
+	return a.Authenticate(r)
+}
+
+func TestHMACAuthenticate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := NewHMACAuthenticator([]byte("secret"))
+	a.DefaultPlans = []string{"small"}
+	a.now = func() time.Time { return now }
+
+	valid, err := a.Sign("user-1", time.Hour, "large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	noPlans, _ := a.Sign("user-2", time.Hour)
+	expired, _ := a.Sign("user-1", -time.Second)
+	other := NewHMACAuthenticator([]byte("other secret"))
+	other.now = a.now
+	forged, _ := other.Sign("user-1", time.Hour)
+	//user-2's claims under user-1's signature:
+	_, sig, _ := strings.Cut(valid, ".")
+	payload, _, _ := strings.Cut(noPlans, ".")
+	tampered := payload + "." + sig
+
+	identity, err := authenticate(a, valid)
+	if err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+	if want := (&Identity{UserID: "user-1", Plans: []string{"large"}}); !reflect.DeepEqual(identity, want) {
+		t.Errorf("valid token = %+v, want %+v", identity, want)
+	}
+	if identity, err := authenticate(a, noPlans); err != nil || !reflect.DeepEqual(identity.Plans, []string{"small"}) {
+		t.Errorf("token without plans = %+v, %v; want the default plans", identity, err)
+	}
+
+	for name, token := range map[string]string{
+		"expired":       expired,
+		"bad signature": forged,
+		"tampered":      tampered,
+		"malformed":     "not-a-token",
+		"missing":       "",
+	} {
+		if _, err := authenticate(a, token); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("%s token: err = %v, want ErrUnauthenticated", name, err)
+		}
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ws?token=query", nil)
+	if got := bearerToken(r); got != "query" {
+		t.Errorf("query token = %q", got)
+	}
+	r.Header.Set("Authorization", "Bearer header")
+	if got := bearerToken(r); got != "header" {
+		t.Errorf("header token = %q, want it preferred over the query", got)
+	}
+}
@@ -1,12 +1,12 @@
 package aws
 
 import (
-    "context"
-    "log"
-    "os"
+	"context"
+	"log"
+	"os"
 
-    "github.com/aws/aws-sdk-go-v2/aws"
-    "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
@@ -15,14 +15,20 @@ var s3Client *s3.Client
 
 func InitAWSConfig() {
 
-    region := os.Getenv("AWS_REGION")
-    
-    cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
-    if err != nil {
-        log.Fatalf("failed to load configuration, %v", err)
-    }
+	region := os.Getenv("AWS_REGION")
 
-    AwsConfig = cfg
-	s3Client = s3.NewFromConfig(AwsConfig)
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		log.Fatalf("failed to load configuration, %v", err)
+	}
+
+	AwsConfig = cfg
+	s3Client = s3.NewFromConfig(AwsConfig, func(o *s3.Options) {
+		//custom endpoint for S3 compatible stores such as MinIO:
+		if endpoint := os.Getenv("AWS_S3_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
 	log.Println("AWS configuration initialized successfully")
 }
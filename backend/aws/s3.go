@@ -2,81 +2,309 @@ package aws
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mudit06mah/CloudIde/templates"
 )
 
-func DownloadTemplate( projectType string, workspaceId string ) (string, error) {
-    fmt.Println("Reached DownloadTemplate with projectType:", projectType)
-    var prefix string
-    //Determine Prefix based on project type
-    switch projectType {
-        case "nodejs":
-            prefix = "templates/node/"
-        case "python":
-            prefix = "templates/python/"
-        case "golang":
-            prefix = "templates/golang/"
-        case "cpp":
-            prefix = "templates/cpp/"
-        case "react":
-            prefix = "templates/react/"
-        default:
-            return "", fmt.Errorf("unsupported project type: %s", projectType)
-    }
-    
-    cacheDir := filepath.Join(os.Getenv("CACHE_DIR"),workspaceId)
-    bucket := os.Getenv("AWS_S3_BUCKET")
-   
-    //List folder contents:
-    resp, err := s3Client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-        Bucket: &bucket,
-        Prefix: &prefix,
-    })
-    if err != nil {
-        return "", fmt.Errorf("failed to list object with prefix %s: %v", prefix, err)
-    }
-
-    for _, obj := range resp.Contents {
-        key := *obj.Key
-        if( key == prefix || strings.HasSuffix(key, "/") ) {
-            continue    //Skip directories
-        }
-
-        relPath := strings.TrimPrefix(key, prefix)
-        localPath := filepath.Join(cacheDir, relPath)
-
-        if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
-            return "", fmt.Errorf("failed to create directory %s: %v", filepath.Dir(localPath), err)
-        }
-
-        out,err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
-            Bucket: &bucket,
-            Key:    &key,
-        })
-        if err != nil{
-            return "", fmt.Errorf("failed to get object %s: %v", key, err)
-        }
-        defer out.Body.Close()
-
-        //Add to cache folder
-        file,err := os.Create(localPath)
-        if err != nil {
-            return "", fmt.Errorf("failed to create file %s: %v", localPath, err)
-        }
-        defer file.Close()
-
-        _,err = io.Copy(file, out.Body)
-        if err != nil {
-            return "", fmt.Errorf("failed to copy object %s to file %s: %v", key, localPath, err)
-        }
-    }
-
-    return cacheDir,nil
-    
-}
\ No newline at end of file
+const defaultDownloadWorkers = 8
+
+// S3API is the part of the S3 client templates are downloaded with
+type S3API interface {
+	s3.ListObjectsV2APIClient
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// S3TemplateSource downloads templates from templates/<name>/ in a bucket, through Client
+// or the client set up by InitAWSConfig when nil.
+// With CacheDir set, each template is kept once under CacheDir/<name>/<version>
+// (version being a hash of the objects' keys and ETags) and copied into workspaces from there.
+type S3TemplateSource struct {
+	Client   S3API
+	Bucket   string
+	Workers  int
+	CacheDir string
+
+	locks sync.Map // template name -> *sync.Mutex
+}
+
+// NewS3TemplateSource reads AWS_S3_BUCKET and TEMPLATE_DOWNLOAD_WORKERS (default 8),
+// caching templates in CACHE_DIR/.templates
+func NewS3TemplateSource() *S3TemplateSource {
+	workers, err := strconv.Atoi(os.Getenv("TEMPLATE_DOWNLOAD_WORKERS"))
+	if err != nil || workers <= 0 {
+		workers = defaultDownloadWorkers
+	}
+	return &S3TemplateSource{
+		Bucket:   os.Getenv("AWS_S3_BUCKET"),
+		Workers:  workers,
+		CacheDir: filepath.Join(os.Getenv("CACHE_DIR"), ".templates"),
+	}
+}
+
+func (t *S3TemplateSource) client() S3API {
+	if t.Client != nil {
+		return t.Client
+	}
+	return s3Client
+}
+
+func (t *S3TemplateSource) Fetch(ctx context.Context, name string, cacheDir string) error {
+	if name == "" || strings.Contains(name, "/") || name == "." || name == ".." {
+		return fmt.Errorf("invalid template name: %s", name)
+	}
+	prefix := "templates/" + name + "/"
+
+	objects, err := t.listObjects(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	if t.CacheDir == "" {
+		return t.download(ctx, prefix, objects, cacheDir)
+	}
+
+	return t.copyCachedTemplate(ctx, name, prefix, objects, cacheDir)
+}
+
+// copyCachedTemplate copies the local copy of the template matching objects into dest,
+// downloading it only when the ETags have changed since the last fetch. The copy happens
+// under the template's lock, so a concurrent fetch never prunes the version being copied.
+func (t *S3TemplateSource) copyCachedTemplate(ctx context.Context, name string, prefix string, objects []types.Object, dest string) error {
+	lock, _ := t.locks.LoadOrStore(name, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	versionDir, err := t.cachedVersion(ctx, name, prefix, objects)
+	if err != nil {
+		return err
+	}
+	return templates.CopyDir(versionDir, dest)
+}
+
+// cachedVersion returns the folder holding the template matching objects, downloading it
+// if needed and dropping older versions; the caller holds the template's lock
+func (t *S3TemplateSource) cachedVersion(ctx context.Context, name string, prefix string, objects []types.Object) (string, error) {
+	baseDir := filepath.Join(t.CacheDir, name)
+	versionDir := filepath.Join(baseDir, templateVersion(objects))
+	if _, err := os.Stat(versionDir); err == nil {
+		return versionDir, nil
+	}
+
+	//download into a partial folder (resumed if a previous fetch was interrupted) then swap it in:
+	partialDir := versionDir + ".partial"
+	if err := os.MkdirAll(partialDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %v", partialDir, err)
+	}
+	if err := t.download(ctx, prefix, objects, partialDir); err != nil {
+		return "", err
+	}
+	if err := os.Rename(partialDir, versionDir); err != nil {
+		return "", fmt.Errorf("failed to move template %s into the cache: %v", name, err)
+	}
+
+	//drop versions that no longer match the bucket:
+	entries, err := os.ReadDir(baseDir)
+	if err == nil {
+		for _, entry := range entries {
+			if path := filepath.Join(baseDir, entry.Name()); path != versionDir {
+				os.RemoveAll(path)
+			}
+		}
+	}
+	return versionDir, nil
+}
+
+// templateVersion hashes the key, ETag and size of every object
+func templateVersion(objects []types.Object) string {
+	lines := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		lines = append(lines, fmt.Sprintf("%s %s %d", aws.ToString(obj.Key), aws.ToString(obj.ETag), aws.ToInt64(obj.Size)))
+	}
+	sort.Strings(lines)
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// download fetches objects into dir with a bounded worker pool. Files already present
+// with a matching size and ETag are skipped, so an interrupted download can be resumed.
+func (t *S3TemplateSource) download(ctx context.Context, prefix string, objects []types.Object, dir string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan types.Object)
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+
+	workers := t.Workers
+	if workers <= 0 {
+		workers = defaultDownloadWorkers
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range jobs {
+				if err := t.downloadObject(ctx, prefix, obj, dir); err != nil {
+					//keep the first error and stop everything else:
+					select {
+					case errs <- err:
+					default:
+					}
+					cancel()
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, obj := range objects {
+		select {
+		case jobs <- obj:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+	return ctx.Err()
+}
+
+// listObjects pages through the prefix, returning every file below it
+func (t *S3TemplateSource) listObjects(ctx context.Context, prefix string) ([]types.Object, error) {
+	paginator := s3.NewListObjectsV2Paginator(t.client(), &s3.ListObjectsV2Input{
+		Bucket: &t.Bucket,
+		Prefix: &prefix,
+	})
+
+	var objects []types.Object
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list object with prefix %s: %v", prefix, err)
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if key == prefix || strings.HasSuffix(key, "/") {
+				continue //Skip directories
+			}
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+func (t *S3TemplateSource) downloadObject(ctx context.Context, prefix string, obj types.Object, cacheDir string) error {
+	key := aws.ToString(obj.Key)
+	relPath := strings.TrimPrefix(key, prefix)
+	localPath := filepath.Join(cacheDir, filepath.FromSlash(relPath))
+
+	rel, err := filepath.Rel(cacheDir, localPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("object %s escapes the workspace", key)
+	}
+
+	if isDownloaded(localPath, obj) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", filepath.Dir(localPath), err)
+	}
+
+	out, err := t.client().GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &t.Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get object %s: %v", key, err)
+	}
+	defer out.Body.Close()
+
+	//write next to the destination and rename, so a partial file is never left in place:
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".part-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %v", localPath, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), out.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy object %s to file %s: %v", key, localPath, err)
+	}
+
+	if err := verifyObject(obj, size, hex.EncodeToString(hash.Sum(nil))); err != nil {
+		return fmt.Errorf("object %s: %v", key, err)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), localPath); err != nil {
+		return fmt.Errorf("failed to move %s into place: %v", localPath, err)
+	}
+	return nil
+}
+
+// verifyObject checks size and, for single part uploads, the MD5 ETag.
+// Multipart ETags ("<hash>-<parts>") aren't a content hash, so only the size is checked.
+func verifyObject(obj types.Object, size int64, md5sum string) error {
+	if obj.Size != nil && *obj.Size != size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", *obj.Size, size)
+	}
+
+	etag := strings.Trim(aws.ToString(obj.ETag), `"`)
+	if etag != "" && !strings.Contains(etag, "-") && etag != md5sum {
+		return fmt.Errorf("checksum mismatch: etag %s, got %s", etag, md5sum)
+	}
+	return nil
+}
+
+// isDownloaded is true when localPath already matches the object
+func isDownloaded(localPath string, obj types.Object) bool {
+	info, err := os.Stat(localPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	if obj.Size != nil && info.Size() != *obj.Size {
+		return false
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return false
+	}
+	return verifyObject(obj, size, hex.EncodeToString(hash.Sum(nil))) == nil
+}
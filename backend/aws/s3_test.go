@@ -0,0 +1,419 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeS3 is an in-memory bucket. Listings come in pages of pageSize keys, ETags are the MD5
+// of the content unless overridden, and every GetObject is counted per key.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	etags    map[string]string
+	sizes    map[string]int64
+	pageSize int
+	lists    int
+	gets     map[string]int
+	// get, when set, serves GetObject instead of the stored content
+	get func(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte), etags: make(map[string]string), sizes: make(map[string]int64), gets: make(map[string]int)}
+}
+
+func (f *fakeS3) put(key string, content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = []byte(content)
+}
+
+func (f *fakeS3) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lists++
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, aws.ToString(in.Prefix)) && key > aws.ToString(in.ContinuationToken) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	pageSize := f.pageSize
+	if pageSize <= 0 {
+		pageSize = 1000
+	}
+
+	out := &s3.ListObjectsV2Output{}
+	if len(keys) > pageSize {
+		keys = keys[:pageSize]
+		out.IsTruncated = aws.Bool(true)
+		out.NextContinuationToken = aws.String(keys[len(keys)-1])
+	}
+	for _, key := range keys {
+		sum := md5.Sum(f.objects[key])
+		etag, ok := f.etags[key]
+		if !ok {
+			etag = `"` + hex.EncodeToString(sum[:]) + `"`
+		}
+		size, ok := f.sizes[key]
+		if !ok {
+			size = int64(len(f.objects[key]))
+		}
+		out.Contents = append(out.Contents, types.Object{Key: aws.String(key), ETag: aws.String(etag), Size: aws.Int64(size)})
+	}
+	return out, nil
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	key := aws.ToString(in.Key)
+	f.mu.Lock()
+	f.gets[key]++
+	content, ok := f.objects[key]
+	get := f.get
+	f.mu.Unlock()
+
+	if get != nil {
+		body, err := get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return &s3.GetObjectOutput{Body: body}, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("NoSuchKey: %s", key)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(content))}, nil
+}
+
+// downloads is how many times each key was fetched, and in total
+func (f *fakeS3) downloads() (map[string]int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	gets := make(map[string]int)
+	total := 0
+	for key, n := range f.gets {
+		gets[key] = n
+		total += n
+	}
+	return gets, total
+}
+
+// readFiles returns every regular file under dir by slash separated path
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestS3FetchPaginates(t *testing.T) {
+	bucket := newFakeS3()
+	for i := 0; i < 2500; i++ {
+		bucket.put(fmt.Sprintf("templates/big/f%04d.txt", i), fmt.Sprint(i))
+	}
+	//folder markers are skipped:
+	bucket.put("templates/big/", "")
+	bucket.put("templates/big/sub/", "")
+	bucket.put("templates/other/main.go", "package main")
+
+	source := &S3TemplateSource{Client: bucket, Bucket: "test", Workers: 4}
+	dest := t.TempDir()
+	if err := source.Fetch(context.Background(), "big", dest); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readFiles(t, dest)
+	if len(files) != 2500 || files["f2499.txt"] != "2499" || files["f0000.txt"] != "0" {
+		t.Errorf("fetched %d files, want 2500 with their content", len(files))
+	}
+	if bucket.lists != 3 {
+		t.Errorf("listed %d pages, want 3", bucket.lists)
+	}
+	if _, total := bucket.downloads(); total != 2500 {
+		t.Errorf("downloaded %d objects, want 2500", total)
+	}
+}
+
+// TestS3DownloadError checks the first failed object fails the fetch and cancels the
+// downloads still running, instead of waiting for them
+func TestS3DownloadError(t *testing.T) {
+	bucket := newFakeS3()
+	for i := 0; i < 20; i++ {
+		bucket.put(fmt.Sprintf("templates/python/f%02d.py", i), "x")
+	}
+	failure := errors.New("access denied")
+	bucket.get = func(ctx context.Context, key string) (io.ReadCloser, error) {
+		if strings.HasSuffix(key, "f00.py") {
+			return nil, failure
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	source := &S3TemplateSource{Client: bucket, Bucket: "test", Workers: 4}
+	done := make(chan error, 1)
+	go func() { done <- source.Fetch(context.Background(), "python", t.TempDir()) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), failure.Error()) {
+			t.Errorf("Fetch = %v, want the failed object's error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Fetch still waiting on the other downloads")
+	}
+	if _, total := bucket.downloads(); total > 4+1 {
+		t.Errorf("%d downloads started after the failure, want at most one per worker", total)
+	}
+}
+
+// TestS3DownloadResume interrupts a cached download halfway, then checks the next fetch
+// keeps the files that made it, replaces the damaged ones and never leaves partial files
+func TestS3DownloadResume(t *testing.T) {
+	bucket := newFakeS3()
+	bucket.put("templates/node/a.js", "alpha")
+	bucket.put("templates/node/b.js", "bravo")
+	bucket.put("templates/node/lib/c.js", "charlie")
+	source := &S3TemplateSource{Client: bucket, Bucket: "test", Workers: 1, CacheDir: t.TempDir()}
+
+	//c.js breaks off midway through its body:
+	bucket.get = func(ctx context.Context, key string) (io.ReadCloser, error) {
+		content := bucket.objects[key]
+		if strings.HasSuffix(key, "c.js") {
+			return io.NopCloser(io.MultiReader(bytes.NewReader(content[:3]), &failingReader{})), nil
+		}
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}
+	if err := source.Fetch(context.Background(), "node", t.TempDir()); err == nil {
+		t.Fatal("Fetch with a broken body succeeded")
+	}
+
+	objects, err := source.listObjects(context.Background(), "templates/node/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	partialDir := filepath.Join(source.CacheDir, "node", templateVersion(objects)+".partial")
+	if got := readFiles(t, partialDir); !reflect.DeepEqual(got, map[string]string{"a.js": "alpha", "b.js": "bravo"}) {
+		t.Errorf("partial download holds %v, want only the complete files", got)
+	}
+	//damage one of the kept files, same size:
+	if err := os.WriteFile(filepath.Join(partialDir, "b.js"), []byte("BRAVO"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket.get = nil
+	dest := t.TempDir()
+	if err := source.Fetch(context.Background(), "node", dest); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a.js": "alpha", "b.js": "bravo", "lib/c.js": "charlie"}
+	if got := readFiles(t, dest); !reflect.DeepEqual(got, want) {
+		t.Errorf("fetched %v, want %v", got, want)
+	}
+	if gets, _ := bucket.downloads(); gets["templates/node/a.js"] != 1 || gets["templates/node/b.js"] != 2 || gets["templates/node/lib/c.js"] != 2 {
+		t.Errorf("downloads %v: a.js should be kept, b.js and c.js fetched again", gets)
+	}
+	if _, err := os.Stat(partialDir); !os.IsNotExist(err) {
+		t.Errorf("partial folder left behind (%v)", err)
+	}
+}
+
+func TestS3DownloadVerifies(t *testing.T) {
+	tests := []struct {
+		name    string
+		etag    string
+		size    int64
+		wantErr string
+	}{
+		{"etag mismatch", `"0123456789abcdef0123456789abcdef"`, -1, "checksum mismatch"},
+		{"size mismatch", "", 100, "size mismatch"},
+		{"multipart etag", `"0123456789abcdef0123456789abcdef-2"`, -1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := newFakeS3()
+			bucket.put("templates/cpp/main.cpp", "int main() {}")
+			if tt.etag != "" {
+				bucket.etags["templates/cpp/main.cpp"] = tt.etag
+			}
+			if tt.size >= 0 {
+				bucket.sizes["templates/cpp/main.cpp"] = tt.size
+			}
+			source := &S3TemplateSource{Client: bucket, Bucket: "test"}
+
+			dest := t.TempDir()
+			err := source.Fetch(context.Background(), "cpp", dest)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := readFiles(t, dest); got["main.cpp"] != "int main() {}" {
+					t.Errorf("fetched %v", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Fetch = %v, want %s", err, tt.wantErr)
+			}
+			//neither the bad file nor its temporary copy stays behind:
+			entries, _ := os.ReadDir(dest)
+			if len(entries) != 0 {
+				t.Errorf("files left after a failed check: %v", entries)
+			}
+		})
+	}
+}
+
+type failingReader struct{}
+
+func (*failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+// versions lists the cached versions of a template
+func versions(t *testing.T, source *S3TemplateSource, name string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(source.CacheDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestTemplateCacheReuse(t *testing.T) {
+	bucket := newFakeS3()
+	bucket.put("templates/golang/main.go", "package main")
+	bucket.put("templates/golang/go.mod", "module app")
+	source := &S3TemplateSource{Client: bucket, Bucket: "test", CacheDir: t.TempDir()}
+
+	first, second := t.TempDir(), t.TempDir()
+	for _, dest := range []string{first, second} {
+		if err := source.Fetch(context.Background(), "golang", dest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, total := bucket.downloads(); total != 2 {
+		t.Errorf("downloaded %d objects over two fetches, want 2", total)
+	}
+	want := map[string]string{"main.go": "package main", "go.mod": "module app"}
+	for _, dest := range []string{first, second} {
+		if got := readFiles(t, dest); !reflect.DeepEqual(got, want) {
+			t.Errorf("fetched %v, want %v", got, want)
+		}
+	}
+
+	//workspaces get copies, editing one leaves the cache alone:
+	if err := os.WriteFile(filepath.Join(first, "main.go"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	third := t.TempDir()
+	if err := source.Fetch(context.Background(), "golang", third); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFiles(t, third); !reflect.DeepEqual(got, want) {
+		t.Errorf("fetched %v after a workspace edit, want %v", got, want)
+	}
+}
+
+func TestTemplateCacheNewVersion(t *testing.T) {
+	bucket := newFakeS3()
+	bucket.put("templates/react/index.html", "v1")
+	bucket.put("templates/react/app.jsx", "app")
+	source := &S3TemplateSource{Client: bucket, Bucket: "test", CacheDir: t.TempDir()}
+
+	if err := source.Fetch(context.Background(), "react", t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	old := versions(t, source, "react")
+
+	bucket.put("templates/react/index.html", "v2")
+	dest := t.TempDir()
+	if err := source.Fetch(context.Background(), "react", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFiles(t, dest); got["index.html"] != "v2" {
+		t.Errorf("fetched index.html %q, want v2", got["index.html"])
+	}
+	current := versions(t, source, "react")
+	if len(old) != 1 || len(current) != 1 || current[0] == old[0] {
+		t.Errorf("cached versions %v after the change (before %v), want one new version", current, old)
+	}
+	if gets, _ := bucket.downloads(); gets["templates/react/index.html"] != 2 {
+		t.Errorf("index.html downloaded %d times, want 2", gets["templates/react/index.html"])
+	}
+}
+
+// TestTemplateCacheConcurrent fetches one template from many workspaces at once: it must
+// be downloaded once and every workspace must get all of it
+func TestTemplateCacheConcurrent(t *testing.T) {
+	bucket := newFakeS3()
+	for i := 0; i < 10; i++ {
+		bucket.put(fmt.Sprintf("templates/python/f%d.py", i), fmt.Sprint(i))
+	}
+	source := &S3TemplateSource{Client: bucket, Bucket: "test", Workers: 2, CacheDir: t.TempDir()}
+
+	dests := make([]string, 8)
+	errs := make([]error, len(dests))
+	var wg sync.WaitGroup
+	for i := range dests {
+		dests[i] = t.TempDir()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = source.Fetch(context.Background(), "python", dests[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, dest := range dests {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if got := readFiles(t, dest); len(got) != 10 {
+			t.Errorf("workspace %d got %d files, want 10", i, len(got))
+		}
+	}
+	if gets, total := bucket.downloads(); total != 10 {
+		t.Errorf("downloaded %d objects for 8 concurrent fetches, want 10: %v", total, gets)
+	}
+	if got := versions(t, source, "python"); len(got) != 1 {
+		t.Errorf("cached versions %v, want one", got)
+	}
+}
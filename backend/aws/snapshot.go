@@ -0,0 +1,223 @@
+package aws
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mudit06mah/CloudIde/archive"
+)
+
+var ErrNoSnapshot = errors.New("no snapshot found")
+
+// SnapshotManifest is written after the archive, so an archive without a
+// manifest (or with a mismatching checksum) is a partial upload.
+type SnapshotManifest struct {
+	WorkspaceID string    `json:"workspaceId"`
+	Archive     string    `json:"archive"`
+	SHA256      string    `json:"sha256"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// SnapshotsEnabled follows SNAPSHOTS_ENABLED when set; otherwise snapshots are on once
+// a bucket (AWS_S3_BUCKET) or S3 endpoint (AWS_S3_ENDPOINT) is configured, so offline
+// setups can stop workspaces without S3
+func SnapshotsEnabled() bool {
+	if enabled, err := strconv.ParseBool(os.Getenv("SNAPSHOTS_ENABLED")); err == nil {
+		return enabled
+	}
+	return os.Getenv("AWS_S3_BUCKET") != "" || os.Getenv("AWS_S3_ENDPOINT") != ""
+}
+
+// snapshotPrefix is SNAPSHOT_PREFIX (default "snapshots/") + workspaceId + "/"
+func snapshotPrefix(workspaceId string) string {
+	prefix := os.Getenv("SNAPSHOT_PREFIX")
+	if prefix == "" {
+		prefix = "snapshots/"
+	}
+	return prefix + workspaceId + "/"
+}
+
+// UploadSnapshot archives CACHE_DIR/<workspaceId> as tar.zst and uploads it
+// along with its manifest. Older archives are removed once the manifest is in place.
+func UploadSnapshot(ctx context.Context, workspaceId string) (*SnapshotManifest, error) {
+	cacheDir := filepath.Join(os.Getenv("CACHE_DIR"), workspaceId)
+	bucket := os.Getenv("AWS_S3_BUCKET")
+	prefix := snapshotPrefix(workspaceId)
+
+	tmp, err := os.CreateTemp("", "snapshot-*.tar.zst")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp archive: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	if err := writeArchive(io.MultiWriter(tmp, hash), cacheDir); err != nil {
+		return nil, err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	sum := hash.Sum(nil)
+	manifest := &SnapshotManifest{
+		WorkspaceID: workspaceId,
+		Archive:     fmt.Sprintf("%s%d.tar.zst", prefix, time.Now().UnixNano()),
+		SHA256:      hex.EncodeToString(sum),
+		Size:        size,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:            &bucket,
+		Key:               &manifest.Archive,
+		Body:              tmp,
+		ContentLength:     aws.Int64(size),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		ChecksumSHA256:    aws.String(base64.StdEncoding.EncodeToString(sum)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload snapshot %s: %v", manifest.Archive, err)
+	}
+
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	manifestKey := prefix + "manifest.json"
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &bucket,
+		Key:         &manifestKey,
+		Body:        strings.NewReader(string(body)),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload snapshot manifest: %v", err)
+	}
+
+	pruneSnapshots(ctx, bucket, prefix, manifest.Archive)
+	return manifest, nil
+}
+
+// RestoreSnapshot downloads the latest complete snapshot into CACHE_DIR/<workspaceId>.
+// It returns ErrNoSnapshot when the workspace has no manifest.
+func RestoreSnapshot(ctx context.Context, workspaceId string) error {
+	cacheDir := filepath.Join(os.Getenv("CACHE_DIR"), workspaceId)
+	bucket := os.Getenv("AWS_S3_BUCKET")
+	manifestKey := snapshotPrefix(workspaceId) + "manifest.json"
+
+	out, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &manifestKey})
+	if err != nil {
+		var noKey *types.NoSuchKey
+		if errors.As(err, &noKey) {
+			return ErrNoSnapshot
+		}
+		return fmt.Errorf("failed to get snapshot manifest: %v", err)
+	}
+	var manifest SnapshotManifest
+	err = json.NewDecoder(out.Body).Decode(&manifest)
+	out.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode snapshot manifest: %v", err)
+	}
+
+	//download to a temp file first so the checksum is verified before anything is extracted:
+	tmp, err := os.CreateTemp("", "restore-*.tar.zst")
+	if err != nil {
+		return fmt.Errorf("failed to create temp archive: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	out, err = s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &manifest.Archive})
+	if err != nil {
+		return fmt.Errorf("failed to get snapshot %s: %v", manifest.Archive, err)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), out.Body)
+	out.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to download snapshot %s: %v", manifest.Archive, err)
+	}
+
+	if size != manifest.Size || hex.EncodeToString(hash.Sum(nil)) != manifest.SHA256 {
+		return fmt.Errorf("snapshot %s is incomplete or corrupt", manifest.Archive)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", cacheDir, err)
+	}
+	return extractArchive(tmp, cacheDir)
+}
+
+// pruneSnapshots deletes archives other than keep; failures only leave garbage behind
+func pruneSnapshots(ctx context.Context, bucket string, prefix string, keep string) {
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{Bucket: &bucket, Prefix: &prefix})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			fmt.Println("Error listing old snapshots:", err)
+			return
+		}
+		for _, obj := range page.Contents {
+			key := *obj.Key
+			if key == keep || !strings.HasSuffix(key, ".tar.zst") {
+				continue
+			}
+			if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucket, Key: &key}); err != nil {
+				fmt.Println("Error deleting old snapshot:", err)
+			}
+		}
+	}
+}
+
+// writeArchive tars dir into w with zstd compression, skipping node_modules and .git
+func writeArchive(w io.Writer, dir string) error {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := archive.Write(zw, dir, "node_modules", ".git"); err != nil {
+		zw.Close()
+		return fmt.Errorf("failed to archive %s: %v", dir, err)
+	}
+	return zw.Close()
+}
+
+// extractArchive unpacks a tar.zst into dir, rejecting entries that escape it
+func extractArchive(r io.Reader, dir string) error {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	if err := archive.Extract(zr, dir); err != nil {
+		return fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	return nil
+}
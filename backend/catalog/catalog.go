@@ -0,0 +1,426 @@
+package catalog
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mudit06mah/CloudIde/k8s/manifests"
+	"github.com/mudit06mah/CloudIde/workspace"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+//go:embed projects.yaml
+var defaultCatalog []byte
+
+// Manifest is one Kubernetes template rendered for a project type.
+// With Storage set it is only rendered for workspaces using that storage backend.
+type Manifest struct {
+	Name      string                   `json:"name"`
+	Path      string                   `json:"path"`
+	Storage   workspace.StorageBackend `json:"storage,omitempty"`
+	Variables map[string]string        `json:"variables,omitempty"`
+}
+
+type Port struct {
+	Name    string `json:"name"`
+	Port    int    `json:"port"`
+	Preview bool   `json:"preview,omitempty"`
+}
+
+// ProjectType describes everything the backend needs to provision one kind of workspace
+type ProjectType struct {
+	Name        string            `json:"name"`
+	DisplayName string            `json:"displayName"`
+	Image       string            `json:"image"`
+	Template    string            `json:"template"`
+	Manifests   []Manifest        `json:"manifests"`
+	Ports       []Port            `json:"ports,omitempty"`
+	Variables   map[string]string `json:"variables,omitempty"`
+	// Profiles restricts the resource profiles offered for this type; empty allows all of them
+	Profiles []string `json:"profiles,omitempty"`
+	// Network overrides the catalogue's network defaults for this type
+	Network Network `json:"network,omitempty"`
+	// RuntimeClassName runs the workspace pods in a sandboxed runtime such as gVisor or Kata
+	RuntimeClassName string `json:"runtimeClassName,omitempty"`
+}
+
+const (
+	// NetworkInternet allows egress anywhere except BlockedCIDRs (the default)
+	NetworkInternet = "internet"
+	// NetworkAllowlist only allows egress to the Egress rules, e.g. package registries
+	NetworkAllowlist = "allowlist"
+	// NetworkIsolated allows no egress but DNS
+	NetworkIsolated = "isolated"
+	// NetworkOpen renders no NetworkPolicy at all
+	NetworkOpen = "open"
+)
+
+// Network is the NetworkPolicy rendered for each workspace. Ingress is always limited to the
+// workspace's own pods plus AllowFromNamespaces and pods matching AllowFromPodLabels, which
+// live in AllowFromPodNamespace (POD_NAMESPACE, the backend's own, when unset).
+type Network struct {
+	Mode                  string            `json:"mode,omitempty"`
+	Egress                []EgressRule      `json:"egress,omitempty"`
+	BlockedCIDRs          []string          `json:"blockedCIDRs,omitempty"`
+	AllowFromNamespaces   []string          `json:"allowFromNamespaces,omitempty"`
+	AllowFromPodLabels    map[string]string `json:"allowFromPodLabels,omitempty"`
+	AllowFromPodNamespace string            `json:"allowFromPodNamespace,omitempty"`
+}
+
+// EgressRule allows TCP traffic to a CIDR, on every port when Ports is empty
+type EgressRule struct {
+	CIDR  string `json:"cidr"`
+	Ports []int  `json:"ports,omitempty"`
+}
+
+// Profile sizes a workspace's shell container. Requests and Limits are keyed by
+// Kubernetes resource name (cpu, memory, ephemeral-storage).
+type Profile struct {
+	Name        string            `json:"name"`
+	DisplayName string            `json:"displayName,omitempty"`
+	Requests    map[string]string `json:"requests,omitempty"`
+	Limits      map[string]string `json:"limits,omitempty"`
+}
+
+type file struct {
+	Profiles       []Profile         `json:"profiles,omitempty"`
+	DefaultProfile string            `json:"defaultProfile,omitempty"`
+	Quota          map[string]string `json:"quota,omitempty"`
+	Network        Network           `json:"network,omitempty"`
+	ProjectTypes   []ProjectType     `json:"projectTypes"`
+}
+
+// Catalog holds the project types and resource profiles, reloaded from Path when it changes (see Watch)
+type Catalog struct {
+	Path string
+	// OnReload is called after Watch picks up a new version of the catalogue
+	OnReload func(*Catalog)
+
+	mu      sync.RWMutex
+	data    *file
+	modTime time.Time
+}
+
+// Load reads the catalogue at path (YAML or JSON), or the built-in one if path is empty
+func Load(path string) (*Catalog, error) {
+	c := &Catalog{Path: path}
+	if path == "" {
+		data, err := parse(defaultCatalog)
+		if err != nil {
+			return nil, fmt.Errorf("invalid built-in catalogue: %v", err)
+		}
+		c.data = data
+		return c, nil
+	}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload re-reads Path; on error, including manifest paths that MANIFEST_DIR and the
+// built-in manifests lack, the current catalogue is kept
+func (c *Catalog) Reload() error {
+	info, err := os.Stat(c.Path)
+	if err != nil {
+		return fmt.Errorf("failed to stat catalogue: %v", err)
+	}
+	data, err := os.ReadFile(c.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read catalogue: %v", err)
+	}
+	parsed, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("invalid catalogue %s: %v", c.Path, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = parsed
+	c.modTime = info.ModTime()
+	return nil
+}
+
+// Watch polls Path every interval and reloads it when modified, until ctx is done
+func (c *Catalog) Watch(ctx context.Context, interval time.Duration) {
+	if c.Path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(c.Path)
+			if err != nil {
+				log.Println("Error checking catalogue:", err)
+				continue
+			}
+
+			c.mu.RLock()
+			changed := !info.ModTime().Equal(c.modTime)
+			c.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err := c.Reload(); err != nil {
+				log.Println("Error reloading catalogue:", err)
+				continue
+			}
+			log.Println("Project catalogue reloaded from", c.Path)
+			if c.OnReload != nil {
+				c.OnReload(c)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Catalog) Get(name string) (ProjectType, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, pt := range c.data.ProjectTypes {
+		if pt.Name == name {
+			return pt, true
+		}
+	}
+	return ProjectType{}, false
+}
+
+// List returns the project types in catalogue order
+func (c *Catalog) List() []ProjectType {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]ProjectType(nil), c.data.ProjectTypes...)
+}
+
+func (c *Catalog) Profile(name string) (Profile, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, p := range c.data.Profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Profiles returns the resource profiles in catalogue order
+func (c *Catalog) Profiles() []Profile {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]Profile(nil), c.data.Profiles...)
+}
+
+// ProfilesFor returns the profiles offered for a project type, the default one first
+func (c *Catalog) ProfilesFor(projectType ProjectType) []Profile {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var profiles []Profile
+	for _, p := range c.data.Profiles {
+		if len(projectType.Profiles) > 0 && !contains(projectType.Profiles, p.Name) {
+			continue
+		}
+		if p.Name == c.data.DefaultProfile {
+			profiles = append([]Profile{p}, profiles...)
+		} else {
+			profiles = append(profiles, p)
+		}
+	}
+	return profiles
+}
+
+// DefaultProfile is the profile used when none is asked for (the first one unless set)
+func (c *Catalog) DefaultProfile() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.data.DefaultProfile
+}
+
+// Quota is the namespace wide ResourceQuota (hard limits by resource name); empty means none
+func (c *Catalog) Quota() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.data.Quota
+}
+
+func contains(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+func parse(data []byte) (*file, error) {
+	var f file
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, err
+	}
+
+	profiles := make(map[string]bool)
+	for _, p := range f.Profiles {
+		if p.Name == "" {
+			return nil, fmt.Errorf("profile without a name")
+		}
+		if profiles[p.Name] {
+			return nil, fmt.Errorf("duplicate profile %s", p.Name)
+		}
+		if err := validateProfile(p); err != nil {
+			return nil, fmt.Errorf("profile %s: %v", p.Name, err)
+		}
+		profiles[p.Name] = true
+	}
+	if f.DefaultProfile == "" && len(f.Profiles) > 0 {
+		f.DefaultProfile = f.Profiles[0].Name
+	}
+	if f.DefaultProfile != "" && !profiles[f.DefaultProfile] {
+		return nil, fmt.Errorf("default profile %s is not defined", f.DefaultProfile)
+	}
+	for name, value := range f.Quota {
+		if _, err := resource.ParseQuantity(value); err != nil {
+			return nil, fmt.Errorf("quota %s: %v", name, err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := range f.ProjectTypes {
+		pt := &f.ProjectTypes[i]
+		switch {
+		case pt.Name == "":
+			return nil, fmt.Errorf("project type without a name")
+		case seen[pt.Name]:
+			return nil, fmt.Errorf("duplicate project type %s", pt.Name)
+		case pt.Image == "":
+			return nil, fmt.Errorf("project type %s has no image", pt.Name)
+		case pt.Template == "":
+			return nil, fmt.Errorf("project type %s has no template", pt.Name)
+		case len(pt.Manifests) == 0:
+			return nil, fmt.Errorf("project type %s has no manifests", pt.Name)
+		}
+		if pt.RuntimeClassName != "" {
+			if errs := validation.IsDNS1123Subdomain(pt.RuntimeClassName); len(errs) > 0 {
+				return nil, fmt.Errorf("project type %s: invalid runtimeClassName: %s", pt.Name, strings.Join(errs, ", "))
+			}
+		}
+		for _, name := range pt.Profiles {
+			if !profiles[name] {
+				return nil, fmt.Errorf("project type %s uses undefined profile %s", pt.Name, name)
+			}
+		}
+		for _, m := range pt.Manifests {
+			if m.Storage != "" && !m.Storage.Valid() {
+				return nil, fmt.Errorf("project type %s: manifest %s has unsupported storage %s", pt.Name, m.Name, m.Storage)
+			}
+			//a typo would otherwise only show up when a workspace of this type is created:
+			if err := manifests.Check(m.Path); err != nil {
+				return nil, fmt.Errorf("project type %s: manifest %s: %v", pt.Name, m.Name, err)
+			}
+		}
+		pt.Network = mergeNetwork(f.Network, pt.Network)
+		if err := validateNetwork(pt.Network); err != nil {
+			return nil, fmt.Errorf("project type %s: %v", pt.Name, err)
+		}
+		seen[pt.Name] = true
+	}
+	return &f, nil
+}
+
+// mergeNetwork fills the fields a project type leaves unset from the catalogue defaults
+func mergeNetwork(defaults Network, network Network) Network {
+	if network.Mode == "" {
+		network.Mode = defaults.Mode
+	}
+	if network.Mode == "" {
+		network.Mode = NetworkInternet
+	}
+	if network.Egress == nil {
+		network.Egress = defaults.Egress
+	}
+	if network.BlockedCIDRs == nil {
+		network.BlockedCIDRs = defaults.BlockedCIDRs
+	}
+	if network.AllowFromNamespaces == nil {
+		network.AllowFromNamespaces = defaults.AllowFromNamespaces
+	}
+	if network.AllowFromPodLabels == nil {
+		network.AllowFromPodLabels = defaults.AllowFromPodLabels
+	}
+	if network.AllowFromPodNamespace == "" {
+		network.AllowFromPodNamespace = defaults.AllowFromPodNamespace
+	}
+	return network
+}
+
+func validateNetwork(network Network) error {
+	switch network.Mode {
+	case NetworkInternet, NetworkAllowlist, NetworkIsolated, NetworkOpen:
+	default:
+		return fmt.Errorf("unsupported network mode %s", network.Mode)
+	}
+	for _, cidr := range network.BlockedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("blocked CIDR: %v", err)
+		}
+	}
+	for _, rule := range network.Egress {
+		if _, _, err := net.ParseCIDR(rule.CIDR); err != nil {
+			return fmt.Errorf("egress rule: %v", err)
+		}
+		for _, port := range rule.Ports {
+			if port < 1 || port > 65535 {
+				return fmt.Errorf("egress rule %s: invalid port %d", rule.CIDR, port)
+			}
+		}
+	}
+	if network.AllowFromPodNamespace != "" {
+		if errs := validation.IsDNS1123Label(network.AllowFromPodNamespace); len(errs) > 0 {
+			return fmt.Errorf("invalid allowFromPodNamespace %s: %s", network.AllowFromPodNamespace, strings.Join(errs, ", "))
+		}
+	}
+	if network.Mode == NetworkAllowlist && len(network.Egress) == 0 {
+		return fmt.Errorf("network mode allowlist needs egress rules")
+	}
+	return nil
+}
+
+// validateProfile checks every quantity parses and no request exceeds its limit
+func validateProfile(p Profile) error {
+	for name, value := range p.Requests {
+		request, err := resource.ParseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("request %s: %v", name, err)
+		}
+		if limitValue, ok := p.Limits[name]; ok {
+			limit, err := resource.ParseQuantity(limitValue)
+			if err != nil {
+				return fmt.Errorf("limit %s: %v", name, err)
+			}
+			if request.Cmp(limit) > 0 {
+				return fmt.Errorf("%s request %s exceeds its limit %s", name, value, limitValue)
+			}
+		}
+	}
+	for name, value := range p.Limits {
+		if _, err := resource.ParseQuantity(value); err != nil {
+			return fmt.Errorf("limit %s: %v", name, err)
+		}
+	}
+	return nil
+}
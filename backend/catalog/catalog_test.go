@@ -0,0 +1,195 @@
+package catalog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProfiles = `
+profiles:
+- name: small
+  requests: {cpu: 100m, memory: 128Mi}
+  limits: {cpu: 500m, memory: 512Mi}
+- name: large
+  limits: {cpu: "2"}
+`
+
+// testCatalog is a valid catalogue with one project type, whose fields extra adds to or overrides
+func testCatalog(extra string) string {
+	return testProfiles + `
+projectTypes:
+- name: python
+  displayName: Python
+  image: python:3.12
+  template: python
+  manifests:
+  - name: pod
+    path: shell-pod.yaml
+` + extra
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog string
+		wantErr string
+	}{
+		{"valid", testCatalog(""), ""},
+		{"storage specific manifest", testCatalog("    storage: volume\n"), ""},
+		{"unknown field", testCatalog("  colour: blue\n"), "unknown field"},
+		{"missing manifest", strings.Replace(testCatalog(""), "shell-pod.yaml", "shell-pod.yml", 1), "manifest pod: manifest shell-pod.yml not found"},
+		{"manifest outside the manifests", strings.Replace(testCatalog(""), "shell-pod.yaml", "../catalog.go", 1), "invalid manifest path"},
+		{"unsupported manifest storage", testCatalog("    storage: nfs\n"), "unsupported storage nfs"},
+		{"duplicate project type", testCatalog("- name: python\n  image: x\n  template: x\n  manifests: [{name: pod, path: shell-pod.yaml}]\n"), "duplicate project type python"},
+		{"no image", strings.Replace(testCatalog(""), "  image: python:3.12\n", "", 1), "has no image"},
+		{"no template", strings.Replace(testCatalog(""), "  template: python\n", "", 1), "has no template"},
+		{"no manifests", testProfiles + "projectTypes:\n- {name: python, image: x, template: x}\n", "has no manifests"},
+		{"undefined profile", testCatalog("  profiles: [medium]\n"), "undefined profile medium"},
+		{"undefined default profile", testCatalog("defaultProfile: medium\n"), "default profile medium is not defined"},
+		{"request over limit", strings.Replace(testCatalog(""), "cpu: 100m", "cpu: \"1\"", 1), "cpu request 1 exceeds its limit 500m"},
+		{"bad quota", testCatalog("quota: {cpu: lots}\n"), "quota cpu"},
+		{"bad runtime class", testCatalog("  runtimeClassName: Not_Valid\n"), "invalid runtimeClassName"},
+		{"bad network mode", testCatalog("network: {mode: everything}\n"), "unsupported network mode everything"},
+		{"allowlist without rules", testCatalog("network: {mode: allowlist}\n"), "needs egress rules"},
+		{"bad blocked CIDR", testCatalog("network: {blockedCIDRs: [10.0.0.0]}\n"), "blocked CIDR"},
+		{"bad egress port", testCatalog("network: {mode: allowlist, egress: [{cidr: 10.0.0.0/8, ports: [70000]}]}\n"), "invalid port 70000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parse([]byte(tt.catalog))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parse: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("parse = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	f, err := parse([]byte(testCatalog("network: {blockedCIDRs: [169.254.0.0/16]}\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.DefaultProfile != "small" {
+		t.Errorf("default profile %q, want the first one", f.DefaultProfile)
+	}
+	network := f.ProjectTypes[0].Network
+	if network.Mode != NetworkInternet || len(network.BlockedCIDRs) != 1 {
+		t.Errorf("network %+v, want internet mode with the catalogue's blocked CIDRs", network)
+	}
+}
+
+func TestLoadBuiltIn(t *testing.T) {
+	c, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.List()) == 0 || c.DefaultProfile() == "" {
+		t.Errorf("built-in catalogue has %d project types, default profile %q", len(c.List()), c.DefaultProfile())
+	}
+}
+
+// TestManifestDir checks manifests only MANIFEST_DIR provides are accepted when it is set
+func TestManifestDir(t *testing.T) {
+	catalog := []byte(strings.Replace(testCatalog(""), "shell-pod.yaml", "custom/pod.yaml", 1))
+	if _, err := parse(catalog); err == nil {
+		t.Fatal("catalogue with a manifest nobody has was accepted")
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "custom"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "custom", "pod.yaml"), []byte("kind: Pod\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MANIFEST_DIR", dir)
+	if _, err := parse(catalog); err != nil {
+		t.Errorf("catalogue with a MANIFEST_DIR manifest: %v", err)
+	}
+}
+
+// writeCatalog writes a catalogue with a modification time of its own, so reloads notice it
+func writeCatalog(t *testing.T, path string, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadKeepsLastGood(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.yaml")
+	start := time.Now().Add(-time.Hour)
+	writeCatalog(t, path, testCatalog(""), start)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeCatalog(t, path, strings.Replace(testCatalog(""), "shell-pod.yaml", "typo.yaml", 1), start.Add(time.Minute))
+	if err := c.Reload(); err == nil {
+		t.Fatal("reload with a missing manifest succeeded")
+	}
+	pt, ok := c.Get("python")
+	if !ok || pt.Manifests[0].Path != "shell-pod.yaml" {
+		t.Errorf("catalogue after a bad reload: %+v, %v; want the previous one", pt, ok)
+	}
+
+	writeCatalog(t, path, strings.Replace(testCatalog(""), "python:3.12", "python:3.13", 1), start.Add(2*time.Minute))
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if pt, _ := c.Get("python"); pt.Image != "python:3.13" {
+		t.Errorf("image %s after reload, want python:3.13", pt.Image)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.yaml")
+	start := time.Now().Add(-time.Hour)
+	writeCatalog(t, path, testCatalog(""), start)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := make(chan string, 10)
+	c.OnReload = func(c *Catalog) {
+		pt, _ := c.Get("python")
+		reloads <- pt.Image
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Watch(ctx, 10*time.Millisecond)
+
+	//a broken version is skipped, the next good one picked up:
+	writeCatalog(t, path, "projectTypes: [", start.Add(time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	writeCatalog(t, path, strings.Replace(testCatalog(""), "python:3.12", "python:3.13", 1), start.Add(2*time.Minute))
+
+	select {
+	case image := <-reloads:
+		if image != "python:3.13" {
+			t.Errorf("reloaded image %s, want python:3.13", image)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("catalogue change not picked up")
+	}
+	select {
+	case image := <-reloads:
+		t.Errorf("unexpected second reload (%s)", image)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
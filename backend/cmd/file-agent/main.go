@@ -0,0 +1,37 @@
+// file-agent serves a workspace's files to the backend from inside the workspace pod.
+// It reads AGENT_ROOT (default /workspace), AGENT_PORT (default 7070) and FILE_AGENT_TOKEN.
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/mudit06mah/CloudIde/agent"
+)
+
+func main() {
+	root := os.Getenv("AGENT_ROOT")
+	if root == "" {
+		root = "/workspace"
+	}
+	port := agent.DefaultPort
+	if env := os.Getenv("AGENT_PORT"); env != "" {
+		var err error
+		if port, err = strconv.Atoi(env); err != nil {
+			log.Fatalf("invalid AGENT_PORT: %v", err)
+		}
+	}
+
+	token := os.Getenv("FILE_AGENT_TOKEN")
+	if token == "" {
+		log.Fatal("FILE_AGENT_TOKEN is not set, refusing to serve files unauthenticated")
+	}
+
+	server := agent.NewServer(root, token)
+	addr := fmt.Sprintf(":%d", port)
+	log.Printf("File agent serving %s on %s\n", root, addr)
+	log.Fatal(http.ListenAndServe(addr, server.Handler()))
+}
@@ -2,18 +2,26 @@ package k8s
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -23,40 +31,38 @@ import (
 	"k8s.io/client-go/tools/remotecommand"
 )
 
-type memoryCache struct {
-	*discovery.DiscoveryClient
-}
-
-func (c *memoryCache) Fresh() bool {
-	//always return true since we're not caching
-	return true
-}
-
-func (c *memoryCache) Invalidate() {
-	// No-op for in-memory cache
-}
+// DiscoveryTTL is how long discovered API resources are trusted before they are fetched again;
+// a kind missing from the cache triggers a refetch sooner
+const DiscoveryTTL = 10 * time.Minute
 
+// Client is the process-wide Kubernetes client; it is safe for concurrent use
 type Client struct {
-	Config	 *rest.Config
-	Clientset *kubernetes.Clientset
-	Dynamic dynamic.Interface
+	Config     *rest.Config
+	Clientset  *kubernetes.Clientset
+	Dynamic    dynamic.Interface
 	RESTMapper *restmapper.DeferredDiscoveryRESTMapper
-	DiscoveryCli *discovery.DiscoveryClient
+	// Discovery caches the server's API resources; RESTMapper.Reset invalidates it
+	Discovery discovery.CachedDiscoveryInterface
+
+	discoveryMu  sync.Mutex
+	discoveredAt time.Time
 }
 
-var workspaceId string
-var namespace string = "cloud-ide"
+// Namespace is where every workspace resource lives
+const Namespace = "cloud-ide"
 
-func NewK8sClient(workId string) (*Client, error) {
-	workspaceId = workId
+const namespace = Namespace
 
+// NewK8sClient connects to the cluster in KUBECONFIG, or the one the backend runs in.
+// Create it once and share it: discovery results are cached on the client.
+func NewK8sClient() (*Client, error) {
 	var cfg *rest.Config
 	var err error
 	kubeconfig := os.Getenv("KUBECONFIG")
 
 	if kubeconfig != "" {
-		cfg,err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-	}else{
+		cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
 		cfg, err = rest.InClusterConfig()
 	}
 
@@ -64,7 +70,7 @@ func NewK8sClient(workId string) (*Client, error) {
 		return nil, fmt.Errorf("failed to create Kubernetes config: %v", err)
 	}
 
-	clientset,err := kubernetes.NewForConfig(cfg)
+	clientset, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Kubernetes clientset: %v", err)
 	}
@@ -79,156 +85,273 @@ func NewK8sClient(workId string) (*Client, error) {
 		return nil, fmt.Errorf("failed to create discovery client: %v", err)
 	}
 
-	restMapper := restmapper.NewDeferredDiscoveryRESTMapper(&memoryCache{discoveryClient})
-
+	cachedDiscovery := memory.NewMemCacheClient(discoveryClient)
+	restMapper := restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscovery)
 
 	return &Client{
-		Config:        cfg,
-		Clientset:     clientset,
-		Dynamic:       dynamicClient,
-		RESTMapper:    restMapper,
-		DiscoveryCli:  discoveryClient,
-	},nil
-		
+		Config:       cfg,
+		Clientset:    clientset,
+		Dynamic:      dynamicClient,
+		RESTMapper:   restMapper,
+		Discovery:    cachedDiscovery,
+		discoveredAt: time.Now(),
+	}, nil
+}
+
+// expireDiscovery drops the cached API resources once they are older than DiscoveryTTL,
+// so kinds installed or removed since are picked up
+func (c *Client) expireDiscovery() {
+	c.discoveryMu.Lock()
+	defer c.discoveryMu.Unlock()
+
+	if time.Since(c.discoveredAt) > DiscoveryTTL {
+		c.RESTMapper.Reset()
+		c.discoveredAt = time.Now()
+	}
 }
 
-func (c *Client) ApplyManifest(ctx context.Context, manifest []byte) error {
+// FieldManager owns every field the backend applies
+const FieldManager = "cloud-ide-backend"
+
+// Labels stamped on every workspace object so it can be found without guessing names
+const (
+	LabelWorkspace = "workspace"
+	LabelOwner     = "owner"
+)
+
+// WorkspaceLabels builds the labels for a workspace; owners that aren't valid label
+// values (e.g. emails) are replaced by a hash of the id
+func WorkspaceLabels(workspaceId string, owner string) map[string]string {
+	if len(validation.IsValidLabelValue(owner)) > 0 {
+		sum := sha256.Sum256([]byte(owner))
+		owner = hex.EncodeToString(sum[:])[:40]
+	}
+	return map[string]string{
+		LabelWorkspace: workspaceId,
+		LabelOwner:     owner,
+	}
+}
+
+// ApplyManifest server-side applies every document of a manifest, adding labels to each
+// object, and returns references to the applied objects
+func (c *Client) ApplyManifest(ctx context.Context, manifest []byte, labels map[string]string) ([]ObjectRef, error) {
+	c.expireDiscovery()
+
 	//decode yaml manifest:
 	dec := yaml.NewYAMLOrJSONDecoder(strings.NewReader(string(manifest)), 4096)
-	var rawObj map[string]interface{}
+	var applied []ObjectRef
 	for {
-
+		var rawObj map[string]interface{}
 		if err := dec.Decode(&rawObj); err != nil {
-			if err.Error() == "EOF" {
+			if err == io.EOF {
 				break
 			}
 
-			return fmt.Errorf("failed to decode manifest: %v", err)
+			return applied, fmt.Errorf("failed to decode manifest: %v", err)
 		}
-	
+		if rawObj == nil {
+			continue
+		}
+
 		u := &unstructured.Unstructured{Object: rawObj}
-	
+
+		objLabels := u.GetLabels()
+		if objLabels == nil {
+			objLabels = make(map[string]string)
+		}
+		for k, v := range labels {
+			objLabels[k] = v
+		}
+		u.SetLabels(objLabels)
+
 		//create mapping:
 		apiVer := u.GetAPIVersion()
 		kind := u.GetKind()
-		gv,err := schema.ParseGroupVersion(apiVer)
-		if err != nil{
-			return fmt.Errorf("error while parsing GV: %v", err)
+		gv, err := schema.ParseGroupVersion(apiVer)
+		if err != nil {
+			return applied, fmt.Errorf("error while parsing GV: %v", err)
 		}
-		gk:= schema.GroupKind{Group: gv.Group, Kind: kind}
-		
-		mapping,err := c.RESTMapper.RESTMapping(gk,gv.Version)
-		if err != nil{
+		gk := schema.GroupKind{Group: gv.Group, Kind: kind}
+
+		mapping, err := c.RESTMapper.RESTMapping(gk, gv.Version)
+		if err != nil {
+			//the kind may be newer than the cache, refetch discovery once:
 			c.RESTMapper.Reset()
-			mapping,err = c.RESTMapper.RESTMapping(gk,gv.Version)
-			if err != nil{
-				return fmt.Errorf("failed RESTMapping for %s %s: %v", apiVer,kind,err)
+			mapping, err = c.RESTMapper.RESTMapping(gk, gv.Version)
+			if err != nil {
+				return applied, fmt.Errorf("failed RESTMapping for %s %s: %v", apiVer, kind, err)
 			}
 		}
 
-		//create or update resource:
+		//server-side apply; Force takes over fields last written by an older client-side update
 		name := u.GetName()
-
 		resourceInterface := c.Dynamic.Resource(mapping.Resource).Namespace(namespace)
 
-		_,err = resourceInterface.Create(ctx,u,metav1.CreateOptions{})
-		if err == nil{
-			continue
+		_, err = resourceInterface.Apply(ctx, name, u, metav1.ApplyOptions{FieldManager: FieldManager, Force: true})
+		if err != nil {
+			return applied, fmt.Errorf("error applying resource %s %s: %v", kind, name, err)
 		}
-		
-		if errors.IsAlreadyExists(err){
-			existing,getErr := resourceInterface.Get(ctx,name,metav1.GetOptions{})
-			if getErr != nil{
-				return fmt.Errorf("error getting existing resource %s %s: %v",kind,name,getErr)
-			}
+		applied = append(applied, ObjectRef{APIVersion: apiVer, Kind: kind, Name: name})
+	}
 
-			u.SetResourceVersion(existing.GetResourceVersion())
-			_,updateErr := resourceInterface.Update(ctx,u,metav1.UpdateOptions{})
-			if updateErr != nil{
-				return fmt.Errorf("error updating existing resource %s %s: %v", kind,name, updateErr)
+	return applied, nil
+}
+
+// ObjectRef identifies an object declared in a manifest
+type ObjectRef struct {
+	APIVersion string
+	Kind       string
+	Name       string
+}
+
+// ManifestObjects lists the kind and name of every document in a manifest
+func ManifestObjects(manifest []byte) ([]ObjectRef, error) {
+	dec := yaml.NewYAMLOrJSONDecoder(strings.NewReader(string(manifest)), 4096)
+	var refs []ObjectRef
+	for {
+		var rawObj map[string]interface{}
+		if err := dec.Decode(&rawObj); err != nil {
+			if err == io.EOF {
+				break
 			}
+			return nil, fmt.Errorf("failed to decode manifest: %v", err)
+		}
+		if rawObj == nil {
 			continue
 		}
-		return fmt.Errorf("error creating resource %s %s: %v", kind,name,err)
-	}
 
-	return nil
+		u := &unstructured.Unstructured{Object: rawObj}
+		refs = append(refs, ObjectRef{APIVersion: u.GetAPIVersion(), Kind: u.GetKind(), Name: u.GetName()})
+	}
+	return refs, nil
 }
 
-func (c *Client) DeleteResource(ctx context.Context, kind string, name string, namespace string) error {
-	var gvrMap = map[string]schema.GroupVersionResource{
-		"Job": {Group: "batch", Version: "v1", Resource: "jobs"},
-		"Pod": {Group: "", Version: "v1", Resource:"pods"},
-		"Deployment": {Group: "apps", Version: "v1", Resource: "deployments"},
-		"Service": {Group: "", Version: "v1", Resource: "services"},
-		"Ingress": {Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"},
-		"Role": {Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"},
-		"RoleBinding": {Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "rolebindings"},
-		"ServiceAccount": {Group: "", Version: "v1", Resource: "serviceaccounts"},
+// DeleteWorkspace deletes every namespaced object labelled workspace=<id>, whatever its kind,
+// and waits until they are all gone. Objects that are already gone count as deleted. Only
+// kinds the backend may list and delete are looked at; it never creates any other kind.
+func (c *Client) DeleteWorkspace(ctx context.Context, workspaceId string) error {
+	resources, err := c.namespacedResources()
+	if err != nil {
+		return err
 	}
+	resources = c.permittedResources(ctx, resources)
 
-	gvr, ok := gvrMap[kind]
-	if !ok{
-		return fmt.Errorf("error finding GVR of kind %s",kind)
-	}
+	selector := fmt.Sprintf("%s=%s", LabelWorkspace, workspaceId)
+	propagation := metav1.DeletePropagationForeground
 
-	if namespace == ""{
-		namespace = "default"
+	for _, gvr := range resources {
+		resourceInterface := c.Dynamic.Resource(gvr).Namespace(namespace)
+		list, err := resourceInterface.List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			if errors.IsNotFound(err) || errors.IsMethodNotSupported(err) || errors.IsForbidden(err) {
+				continue
+			}
+			return fmt.Errorf("error listing %s: %v", gvr.Resource, err)
+		}
+
+		for _, item := range list.Items {
+			err := resourceInterface.Delete(ctx, item.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation})
+			if err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("error deleting %s %s: %v", item.GetKind(), item.GetName(), err)
+			}
+		}
 	}
 
-	return c.Dynamic.Resource(gvr).Namespace(namespace).Delete(ctx,name,metav1.DeleteOptions{})
+	//foreground deletion keeps the owner around until its dependents are gone, so wait for all of them:
+	return wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+		for _, gvr := range resources {
+			list, err := c.Dynamic.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector, Limit: 1})
+			if err != nil {
+				if errors.IsNotFound(err) || errors.IsMethodNotSupported(err) || errors.IsForbidden(err) {
+					continue
+				}
+				return false, fmt.Errorf("error listing %s: %v", gvr.Resource, err)
+			}
+			if len(list.Items) > 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
 }
 
-func (c *Client) WaitForPodByLabel(ctx context.Context, namespace string, labelSelector string, timeout time.Duration) (string,error){
-	tctx,cancel := context.WithTimeout(ctx,timeout)
-	defer cancel()
+// namespacedResources lists the preferred version of every namespaced resource that can be listed and deleted
+func (c *Client) namespacedResources() ([]schema.GroupVersionResource, error) {
+	c.expireDiscovery()
 
-	watcher,err := c.Clientset.CoreV1().Pods(namespace).Watch(ctx,metav1.ListOptions{
-		LabelSelector: labelSelector,
-	})
-	if err!=nil{
-		return "", fmt.Errorf("error watching pod with label %s: %v", labelSelector,err)
+	lists, err := c.Discovery.ServerPreferredNamespacedResources()
+	if err != nil {
+		//an unavailable aggregated API shouldn't stop cleanup of everything else:
+		if !discovery.IsGroupDiscoveryFailedError(err) || len(lists) == 0 {
+			return nil, fmt.Errorf("error discovering resources: %v", err)
+		}
+		fmt.Println("Warning: partial resource discovery:", err)
+	}
+
+	verbs := []string{"list", "delete"}
+	var resources []schema.GroupVersionResource
+	for _, list := range discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: verbs}, lists) {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, res := range list.APIResources {
+			//skip subresources such as pods/log:
+			if strings.Contains(res.Name, "/") {
+				continue
+			}
+			resources = append(resources, gv.WithResource(res.Name))
+		}
 	}
+	return resources, nil
+}
 
-	ch := watcher.ResultChan()
-	defer watcher.Stop()
+// permittedResources keeps the resources the service account may list and delete in the
+// namespace, according to a SelfSubjectRulesReview. When the review fails or is incomplete
+// every resource is kept, and forbidden lists are skipped instead.
+func (c *Client) permittedResources(ctx context.Context, resources []schema.GroupVersionResource) []schema.GroupVersionResource {
+	review, err := c.Clientset.AuthorizationV1().SelfSubjectRulesReviews().Create(ctx, &authorizationv1.SelfSubjectRulesReview{
+		Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: namespace},
+	}, metav1.CreateOptions{})
+	if err != nil || review.Status.Incomplete {
+		return resources
+	}
 
-	list,err := c.Clientset.CoreV1().Pods(namespace).List(ctx,metav1.ListOptions{LabelSelector: labelSelector})
+	var permitted []schema.GroupVersionResource
+	for _, gvr := range resources {
+		if allows(review.Status.ResourceRules, gvr, "list") && allows(review.Status.ResourceRules, gvr, "delete") {
+			permitted = append(permitted, gvr)
+		}
+	}
+	return permitted
+}
 
-	if err==nil{
-		for _,p := range list.Items{
-			if isPodReady(&p){
-				return p.Name,nil
-			}
+// allows reports whether any rule grants verb on the resource
+func allows(rules []authorizationv1.ResourceRule, gvr schema.GroupVersionResource, verb string) bool {
+	for _, rule := range rules {
+		if matches(rule.Verbs, verb) && matches(rule.APIGroups, gvr.Group) && matches(rule.Resources, gvr.Resource) {
+			return true
 		}
 	}
+	return false
+}
 
-	for{
-		select{
-		case ev,ok := <-ch:
-			if !ok{
-				return "",fmt.Errorf("pod watch channel closed")
-			}
-			if ev.Object == nil{
-				continue
-			}
-			pod := ev.Object.(*corev1.Pod)
-			if isPodReady(pod){
-				return pod.Name,nil
-			}
-		case <-tctx.Done():
-			return "",fmt.Errorf("timeout waiting for pod with label %s", labelSelector)
+func matches(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == "*" {
+			return true
 		}
 	}
+	return false
 }
 
-func isPodReady(p *corev1.Pod) bool{
-	if p.Status.Phase != corev1.PodRunning{
+func isPodReady(p *corev1.Pod) bool {
+	if p.Status.Phase != corev1.PodRunning {
 		return false
 	}
 
-	for _,c := range p.Status.Conditions{
-		if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue{
+	for _, c := range p.Status.Conditions {
+		if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue {
 			return true
 		}
 	}
@@ -239,16 +362,16 @@ func isPodReady(p *corev1.Pod) bool{
 func (c *Client) StreamPodLogs(ctx context.Context, namespace string, podName string, follow bool, writer io.Writer) error {
 	req := c.Clientset.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{Follow: follow})
 	stream, err := req.Stream(ctx)
-	if err != nil{
-		return fmt.Errorf("error getting log stream: %v",err)
+	if err != nil {
+		return fmt.Errorf("error getting log stream: %v", err)
 	}
 	defer stream.Close()
 
-	_,err = io.Copy(writer, stream)
+	_, err = io.Copy(writer, stream)
 	return err
 }
 
-func (c *Client) ExecToPod(ctx context.Context, namespace string, podName string, container string, command []string, stdin io.Reader, stdout, stderr io.Writer,tty bool) error{
+func (c *Client) ExecToPod(ctx context.Context, namespace string, podName string, container string, command []string, stdin io.Reader, stdout, stderr io.Writer, tty bool) error {
 	req := c.Clientset.CoreV1().RESTClient().
 		Post().
 		Resource("pods").
@@ -256,24 +379,24 @@ func (c *Client) ExecToPod(ctx context.Context, namespace string, podName string
 		Namespace(namespace).
 		SubResource("exec").
 		VersionedParams(&corev1.PodExecOptions{
-			Command: command,
+			Command:   command,
 			Container: container,
-			Stdin: stdin!=nil,
-			Stdout: stdout!=nil,
-			Stderr: stderr!=nil,
-			TTY: tty,
+			Stdin:     stdin != nil,
+			Stdout:    stdout != nil,
+			Stderr:    stderr != nil,
+			TTY:       tty,
 		}, scheme.ParameterCodec)
 
 	executor, err := remotecommand.NewSPDYExecutor(c.Config, "POST", req.URL())
-	if err != nil{
-		return fmt.Errorf("error executing to pod: %v",err)
+	if err != nil {
+		return fmt.Errorf("error executing to pod: %v", err)
 	}
 
-	return executor.Stream(remotecommand.StreamOptions{
-		Stdin: stdin,
+	return executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
 		Stdout: stdout,
 		Stderr: stderr,
-		Tty: tty,
+		Tty:    tty,
 	})
 
-}
\ No newline at end of file
+}
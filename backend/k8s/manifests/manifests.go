@@ -0,0 +1,45 @@
+// Package manifests holds the Kubernetes templates compiled into the backend, which
+// MANIFEST_DIR can override one file at a time
+package manifests
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+//go:embed *.yaml
+var embedded embed.FS
+
+// Read loads a manifest template by name from MANIFEST_DIR when set and present there,
+// otherwise from the manifests compiled into the binary
+func Read(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, fmt.Errorf("invalid manifest path %s", name)
+	}
+	if dir := os.Getenv("MANIFEST_DIR"); dir != "" {
+		raw, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err == nil || !os.IsNotExist(err) {
+			return raw, err
+		}
+	}
+	return embedded.ReadFile(name)
+}
+
+// Check reports an error unless Read would find name
+func Check(name string) error {
+	if !fs.ValidPath(name) {
+		return fmt.Errorf("invalid manifest path %s", name)
+	}
+	if dir := os.Getenv("MANIFEST_DIR"); dir != "" {
+		if info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err == nil && info.Mode().IsRegular() {
+			return nil
+		}
+	}
+	if _, err := fs.Stat(embedded, name); err != nil {
+		return fmt.Errorf("manifest %s not found in MANIFEST_DIR or the built-in manifests", name)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mudit06mah/CloudIde/archive"
+	"github.com/mudit06mah/CloudIde/workspace"
+)
+
+// WorkspaceMount is where workspace files are mounted in the shell container
+const WorkspaceMount = "/workspace"
+
+const podFSTimeout = 30 * time.Second
+
+// PodFS reaches a workspace's files by exec'ing commands in its shell container,
+// for storage backends where the files only exist inside the cluster
+type PodFS struct {
+	Client    *Client
+	Pod       string
+	Container string
+	Root      string
+}
+
+func NewPodFS(client *Client, podName string) *PodFS {
+	return &PodFS{Client: client, Pod: podName, Container: "shell", Root: WorkspaceMount}
+}
+
+// resolve maps a client path below Root; cleaning "/"+path means it can never climb out
+func (p *PodFS) resolve(clientPath string) string {
+	return path.Join(p.Root, path.Clean("/"+clientPath))
+}
+
+// run execs command with stdin, returning stdout; a non-zero exit becomes an error carrying stderr
+func (p *PodFS) run(ctx context.Context, stdin io.Reader, command ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, podFSTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	err := p.Client.ExecToPod(ctx, namespace, p.Pod, p.Container, command, stdin, &stdout, &stderr, false)
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if strings.Contains(msg, "No such file or directory") {
+			return nil, fs.ErrNotExist
+		}
+		if msg != "" {
+			return nil, fmt.Errorf("%s: %s", command[0], msg)
+		}
+		return nil, fmt.Errorf("%s: %v", command[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+func (p *PodFS) Create(ctx context.Context, clientPath string) error {
+	_, err := p.run(ctx, nil, "sh", "-c", `: > "$1"`, "sh", p.resolve(clientPath))
+	return pathErr("create", clientPath, err)
+}
+
+func (p *PodFS) ReadFile(ctx context.Context, clientPath string) ([]byte, error) {
+	out, err := p.run(ctx, nil, "cat", "--", p.resolve(clientPath))
+	return out, pathErr("open", clientPath, err)
+}
+
+func (p *PodFS) WriteFile(ctx context.Context, clientPath string, data []byte) error {
+	_, err := p.run(ctx, bytes.NewReader(data), "sh", "-c", `cat > "$1"`, "sh", p.resolve(clientPath))
+	return pathErr("write", clientPath, err)
+}
+
+func (p *PodFS) Remove(ctx context.Context, clientPath string) error {
+	full := p.resolve(clientPath)
+	if full == p.Root {
+		return &workspace.PathError{Path: clientPath}
+	}
+	_, err := p.run(ctx, nil, "rm", "-d", "--", full)
+	return pathErr("remove", clientPath, err)
+}
+
+func (p *PodFS) MkdirAll(ctx context.Context, clientPath string) error {
+	_, err := p.run(ctx, nil, "mkdir", "-p", "--", p.resolve(clientPath))
+	return pathErr("mkdir", clientPath, err)
+}
+
+// RemoveAll deletes a folder inside the workspace; the root itself can't be removed this way
+func (p *PodFS) RemoveAll(ctx context.Context, clientPath string) error {
+	full := p.resolve(clientPath)
+	if full == p.Root {
+		return &workspace.PathError{Path: clientPath}
+	}
+	_, err := p.run(ctx, nil, "rm", "-rf", "--", full)
+	return pathErr("remove", clientPath, err)
+}
+
+func (p *PodFS) Rename(ctx context.Context, from string, to string) error {
+	fullFrom, fullTo := p.resolve(from), p.resolve(to)
+	if fullFrom == p.Root || fullTo == p.Root {
+		return &workspace.PathError{Path: from}
+	}
+	_, err := p.run(ctx, nil, "mv", "-T", "--", fullFrom, fullTo)
+	return pathErr("rename", from, err)
+}
+
+func (p *PodFS) ReadDir(ctx context.Context, clientPath string) ([]workspace.Entry, error) {
+	//-p marks folders with a trailing slash:
+	out, err := p.run(ctx, nil, "ls", "-1Ap", "--", p.resolve(clientPath))
+	if err != nil {
+		return nil, pathErr("readdir", clientPath, err)
+	}
+
+	var entries []workspace.Entry
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+		name := strings.TrimSuffix(line, "/")
+		entries = append(entries, workspace.Entry{Name: name, IsDir: name != line})
+	}
+	return entries, nil
+}
+
+// CopyIn uploads the contents of a local folder into the workspace root
+func (p *PodFS) CopyIn(ctx context.Context, localDir string) error {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(archive.Write(writer, localDir))
+	}()
+	defer reader.Close()
+
+	var stderr bytes.Buffer
+	cmd := []string{"tar", "-x", "-m", "-C", p.Root, "-f", "-"}
+	if err := p.Client.ExecToPod(ctx, namespace, p.Pod, p.Container, cmd, reader, nil, &stderr, false); err != nil {
+		return fmt.Errorf("failed to copy files into pod %s: %v %s", p.Pod, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// CopyOut downloads the workspace root into a local folder, skipping node_modules and .git
+func (p *PodFS) CopyOut(ctx context.Context, localDir string) error {
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", localDir, err)
+	}
+
+	reader, writer := io.Pipe()
+	var stderr bytes.Buffer
+	execErr := make(chan error, 1)
+	go func() {
+		cmd := []string{"tar", "-c", "-C", p.Root, "--exclude=./node_modules", "--exclude=./.git", "-f", "-", "."}
+		err := p.Client.ExecToPod(ctx, namespace, p.Pod, p.Container, cmd, nil, writer, &stderr, false)
+		writer.CloseWithError(err)
+		execErr <- err
+	}()
+
+	err := archive.Extract(reader, localDir)
+	if err == nil {
+		//tar pads its output past the end-of-archive marker:
+		_, err = io.Copy(io.Discard, reader)
+	}
+	reader.Close()
+	if streamErr := <-execErr; err == nil {
+		err = streamErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy files out of pod %s: %v %s", p.Pod, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// pathErr wraps missing files so callers can keep using os.IsNotExist
+func pathErr(op string, clientPath string, err error) error {
+	if err == fs.ErrNotExist {
+		return &fs.PathError{Op: op, Path: clientPath, Err: err}
+	}
+	return err
+}
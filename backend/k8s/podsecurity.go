@@ -0,0 +1,256 @@
+package k8s
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mudit06mah/CloudIde/workspace"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// CheckPodSecurity rejects any pod in a rendered manifest that doesn't meet the restricted
+// Pod Security Standard (including the baseline rules it builds on), plus the rules this
+// backend adds on top of it: a read-only root filesystem, no service account token and no
+// container ports below 1024, which non-root containers can't bind. The hostpath storage
+// backend can't avoid its hostPath volume, so that one violation is allowed for it.
+func CheckPodSecurity(manifest []byte, backend workspace.StorageBackend) error {
+	reader := kyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(manifest)))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to split manifest: %v", err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		obj, _, err := strictDecoder.Decode(doc, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		var kind, name string
+		var meta *metav1.ObjectMeta
+		var spec *corev1.PodSpec
+		switch o := obj.(type) {
+		case *corev1.Pod:
+			kind, name, meta, spec = "Pod", o.Name, &o.ObjectMeta, &o.Spec
+		case *appsv1.StatefulSet:
+			kind, name, meta, spec = "StatefulSet", o.Name, &o.Spec.Template.ObjectMeta, &o.Spec.Template.Spec
+		case *appsv1.Deployment:
+			kind, name, meta, spec = "Deployment", o.Name, &o.Spec.Template.ObjectMeta, &o.Spec.Template.Spec
+		case *batchv1.Job:
+			kind, name, meta, spec = "Job", o.Name, &o.Spec.Template.ObjectMeta, &o.Spec.Template.Spec
+		default:
+			continue
+		}
+
+		if violations := restrictedViolations(meta, spec, backend == workspace.StorageHostPath); len(violations) > 0 {
+			return fmt.Errorf("%s %s violates the restricted pod security profile: %s", kind, name, strings.Join(violations, "; "))
+		}
+	}
+}
+
+// restrictedViolations lists what keeps a pod from the restricted profile
+func restrictedViolations(meta *metav1.ObjectMeta, spec *corev1.PodSpec, allowHostPath bool) []string {
+	var violations []string
+	podContext := spec.SecurityContext
+	if podContext == nil {
+		podContext = &corev1.PodSecurityContext{}
+	}
+
+	if spec.AutomountServiceAccountToken == nil || *spec.AutomountServiceAccountToken {
+		violations = append(violations, "automountServiceAccountToken must be false")
+	}
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC {
+		violations = append(violations, "host namespaces are not allowed")
+	}
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			if !allowHostPath {
+				violations = append(violations, fmt.Sprintf("volume %s uses hostPath", volume.Name))
+			}
+		} else if !restrictedVolume(volume.VolumeSource) {
+			violations = append(violations, fmt.Sprintf("volume %s has a type the restricted profile forbids", volume.Name))
+		}
+	}
+	if podContext.RunAsUser != nil && *podContext.RunAsUser == 0 {
+		violations = append(violations, "pod runs as root")
+	}
+	for _, sysctl := range podContext.Sysctls {
+		if !safeSysctls[sysctl.Name] {
+			violations = append(violations, fmt.Sprintf("sysctl %s is not allowed", sysctl.Name))
+		}
+	}
+	if hostProcess(podContext.WindowsOptions) {
+		violations = append(violations, "pod runs as a Windows host process")
+	}
+	if msg := seLinuxViolation(podContext.SELinuxOptions); msg != "" {
+		violations = append(violations, "pod "+msg)
+	}
+	if !appArmorAllowed(podContext.AppArmorProfile) {
+		violations = append(violations, "pod AppArmor profile must be RuntimeDefault or Localhost")
+	}
+	for key, value := range meta.Annotations {
+		if strings.HasPrefix(key, appArmorAnnotationPrefix) && value != "runtime/default" && !strings.HasPrefix(value, "localhost/") {
+			violations = append(violations, fmt.Sprintf("annotation %s must be runtime/default or localhost/*", key))
+		}
+	}
+
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, c := range spec.EphemeralContainers {
+		containers = append(containers, corev1.Container(c.EphemeralContainerCommon))
+	}
+	for _, c := range containers {
+		sc := c.SecurityContext
+		if sc == nil {
+			sc = &corev1.SecurityContext{}
+		}
+		fail := func(msg string) {
+			violations = append(violations, fmt.Sprintf("container %s: %s", c.Name, msg))
+		}
+
+		if sc.Privileged != nil && *sc.Privileged {
+			fail("privileged")
+		}
+		if hostProcess(sc.WindowsOptions) {
+			fail("runs as a Windows host process")
+		}
+		if msg := seLinuxViolation(sc.SELinuxOptions); msg != "" {
+			fail(msg)
+		}
+		if !appArmorAllowed(sc.AppArmorProfile) {
+			fail("AppArmor profile must be RuntimeDefault or Localhost")
+		}
+		if sc.ProcMount != nil && *sc.ProcMount != corev1.DefaultProcMount {
+			fail("procMount must be Default")
+		}
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			fail("allowPrivilegeEscalation must be false")
+		}
+		if !nonRoot(sc.RunAsNonRoot, podContext.RunAsNonRoot) {
+			fail("runAsNonRoot must be true")
+		}
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			fail("runs as root")
+		}
+		if !defaultSeccomp(sc.SeccompProfile, podContext.SeccompProfile) {
+			fail("seccompProfile must be RuntimeDefault or Localhost")
+		}
+		if !dropsAll(sc.Capabilities) {
+			fail("capabilities must drop ALL and add at most NET_BIND_SERVICE")
+		}
+		if sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+			fail("readOnlyRootFilesystem must be true")
+		}
+		for _, port := range c.Ports {
+			if port.HostPort != 0 {
+				fail("hostPort is not allowed")
+			}
+			if port.ContainerPort < 1024 {
+				fail(fmt.Sprintf("containerPort %d is privileged", port.ContainerPort))
+			}
+		}
+	}
+	return violations
+}
+
+// restrictedVolume reports whether a volume has one of the types the restricted profile allows
+func restrictedVolume(source corev1.VolumeSource) bool {
+	return source.ConfigMap != nil || source.CSI != nil || source.DownwardAPI != nil ||
+		source.EmptyDir != nil || source.Ephemeral != nil || source.PersistentVolumeClaim != nil ||
+		source.Projected != nil || source.Secret != nil
+}
+
+// safeSysctls are the sysctls the baseline profile allows pods to set
+var safeSysctls = map[string]bool{
+	"kernel.shm_rmid_forced":              true,
+	"net.ipv4.ip_local_port_range":        true,
+	"net.ipv4.ip_local_reserved_ports":    true,
+	"net.ipv4.ip_unprivileged_port_start": true,
+	"net.ipv4.tcp_syncookies":             true,
+	"net.ipv4.ping_group_range":           true,
+	"net.ipv4.tcp_keepalive_time":         true,
+	"net.ipv4.tcp_fin_timeout":            true,
+	"net.ipv4.tcp_keepalive_intvl":        true,
+	"net.ipv4.tcp_keepalive_probes":       true,
+}
+
+// allowedSELinuxTypes are the SELinux types the baseline profile allows, "" keeping the default
+var allowedSELinuxTypes = map[string]bool{
+	"":                   true,
+	"container_t":        true,
+	"container_init_t":   true,
+	"container_kvm_t":    true,
+	"container_engine_t": true,
+}
+
+// appArmorAnnotationPrefix is the pre-1.30 way of setting a container's AppArmor profile
+const appArmorAnnotationPrefix = "container.apparmor.security.beta.kubernetes.io/"
+
+func hostProcess(options *corev1.WindowsSecurityContextOptions) bool {
+	return options != nil && options.HostProcess != nil && *options.HostProcess
+}
+
+// seLinuxViolation explains what the baseline profile forbids in SELinux options, "" if nothing
+func seLinuxViolation(options *corev1.SELinuxOptions) string {
+	switch {
+	case options == nil:
+		return ""
+	case !allowedSELinuxTypes[options.Type]:
+		return fmt.Sprintf("SELinux type %s is not allowed", options.Type)
+	case options.User != "" || options.Role != "":
+		return "SELinux user and role must not be set"
+	}
+	return ""
+}
+
+func appArmorAllowed(profile *corev1.AppArmorProfile) bool {
+	return profile == nil ||
+		profile.Type == corev1.AppArmorProfileTypeRuntimeDefault || profile.Type == corev1.AppArmorProfileTypeLocalhost
+}
+
+// nonRoot is the container's runAsNonRoot, falling back to the pod's
+func nonRoot(container *bool, pod *bool) bool {
+	if container != nil {
+		return *container
+	}
+	return pod != nil && *pod
+}
+
+// defaultSeccomp is the container's seccomp profile, falling back to the pod's
+func defaultSeccomp(container *corev1.SeccompProfile, pod *corev1.SeccompProfile) bool {
+	profile := container
+	if profile == nil {
+		profile = pod
+	}
+	return profile != nil &&
+		(profile.Type == corev1.SeccompProfileTypeRuntimeDefault || profile.Type == corev1.SeccompProfileTypeLocalhost)
+}
+
+func dropsAll(caps *corev1.Capabilities) bool {
+	if caps == nil {
+		return false
+	}
+	for _, c := range caps.Add {
+		if c != "NET_BIND_SERVICE" {
+			return false
+		}
+	}
+	for _, c := range caps.Drop {
+		if c == "ALL" {
+			return true
+		}
+	}
+	return false
+}
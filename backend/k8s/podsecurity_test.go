@@ -0,0 +1,124 @@
+package k8s
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mudit06mah/CloudIde/catalog"
+	"github.com/mudit06mah/CloudIde/workspace"
+)
+
+// TestCatalogPodSecurity renders every built-in project type for both storage backends,
+// with and without the file agent, and checks each manifest against the restricted profile
+func TestCatalogPodSecurity(t *testing.T) {
+	projectCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, _ := projectCatalog.Profile(projectCatalog.DefaultProfile())
+	agents := map[string]workspace.FileAgent{
+		"no agent": {},
+		"agent":    {Image: "ghcr.io/mudit06mah/file-agent:latest", Secret: "secret"},
+	}
+
+	for _, projectType := range projectCatalog.List() {
+		for _, backend := range []workspace.StorageBackend{workspace.StorageHostPath, workspace.StorageVolume} {
+			for name, agent := range agents {
+				t.Run(projectType.Name+"/"+string(backend)+"/"+name, func(t *testing.T) {
+					storage := workspace.Storage{Backend: backend, Size: "5Gi", Agent: agent}
+					manifests, err := RenderManifests(projectType, RenderOptions{WorkspaceID: "abc123", Storage: storage, Profile: profile})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(manifests) == 0 {
+						t.Fatal("no manifests rendered")
+					}
+					for _, manifest := range manifests {
+						if err := CheckPodSecurity(manifest, backend); err != nil {
+							t.Error(err)
+						}
+						// the hostPath exemption must stay limited to the hostpath backend
+						if backend == workspace.StorageVolume && bytes.Contains(manifest, []byte("hostPath:")) {
+							t.Errorf("volume backend manifest mounts a hostPath:\n%s", manifest)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestCheckPodSecurity(t *testing.T) {
+	const restricted = `apiVersion: v1
+kind: Pod
+metadata:
+  name: shell
+spec:
+  automountServiceAccountToken: false
+  securityContext:
+    runAsNonRoot: true
+    seccompProfile:
+      type: RuntimeDefault
+  containers:
+  - name: shell
+    image: shell
+    securityContext:
+      allowPrivilegeEscalation: false
+      readOnlyRootFilesystem: true
+      capabilities:
+        drop: ["ALL"]
+`
+	const hostPath = restricted + `  volumes:
+  - name: workspace
+    hostPath:
+      path: /workspaces
+`
+	// podContext and containerContext add YAML lines to the pod's and the container's securityContext
+	podContext := func(lines string) string {
+		return strings.Replace(restricted, "    runAsNonRoot: true\n", "    runAsNonRoot: true\n"+lines, 1)
+	}
+	containerContext := func(lines string) string {
+		return strings.Replace(restricted, "      allowPrivilegeEscalation: false\n", "      allowPrivilegeEscalation: false\n"+lines, 1)
+	}
+
+	tests := []struct {
+		name     string
+		manifest string
+		backend  workspace.StorageBackend
+		wantErr  bool
+	}{
+		{"restricted pod", restricted, workspace.StorageVolume, false},
+		{"hostPath on hostpath backend", hostPath, workspace.StorageHostPath, false},
+		{"hostPath on volume backend", hostPath, workspace.StorageVolume, true},
+		{"service account token", strings.Replace(restricted, "automountServiceAccountToken: false", "automountServiceAccountToken: true", 1), workspace.StorageVolume, true},
+		{"writable root filesystem", strings.Replace(restricted, "readOnlyRootFilesystem: true", "readOnlyRootFilesystem: false", 1), workspace.StorageVolume, true},
+		{"privilege escalation", strings.Replace(restricted, "allowPrivilegeEscalation: false", "allowPrivilegeEscalation: true", 1), workspace.StorageVolume, true},
+		{"no manifest", "", workspace.StorageVolume, false},
+		{"allowed volume", restricted + "  volumes:\n  - name: tmp\n    emptyDir: {}\n", workspace.StorageVolume, false},
+		{"forbidden volume", restricted + "  volumes:\n  - name: nfs\n    nfs:\n      server: nfs\n      path: /\n", workspace.StorageHostPath, true},
+		{"safe sysctl", podContext("    sysctls:\n    - name: net.ipv4.ip_local_port_range\n      value: 1024 65535\n"), workspace.StorageVolume, false},
+		{"unsafe sysctl", podContext("    sysctls:\n    - name: kernel.msgmax\n      value: \"65536\"\n"), workspace.StorageVolume, true},
+		{"pod host process", podContext("    windowsOptions:\n      hostProcess: true\n"), workspace.StorageVolume, true},
+		{"container host process", containerContext("      windowsOptions:\n        hostProcess: true\n"), workspace.StorageVolume, true},
+		{"SELinux container type", podContext("    seLinuxOptions:\n      type: container_t\n"), workspace.StorageVolume, false},
+		{"SELinux type", podContext("    seLinuxOptions:\n      type: spc_t\n"), workspace.StorageVolume, true},
+		{"SELinux user", containerContext("      seLinuxOptions:\n        user: system_u\n"), workspace.StorageVolume, true},
+		{"SELinux role", containerContext("      seLinuxOptions:\n        role: system_r\n"), workspace.StorageVolume, true},
+		{"AppArmor default", containerContext("      appArmorProfile:\n        type: RuntimeDefault\n"), workspace.StorageVolume, false},
+		{"AppArmor unconfined pod", podContext("    appArmorProfile:\n      type: Unconfined\n"), workspace.StorageVolume, true},
+		{"AppArmor unconfined container", containerContext("      appArmorProfile:\n        type: Unconfined\n"), workspace.StorageVolume, true},
+		{"AppArmor unconfined annotation", strings.Replace(restricted, "  name: shell\n", "  name: shell\n  annotations:\n    container.apparmor.security.beta.kubernetes.io/shell: unconfined\n", 1), workspace.StorageVolume, true},
+		{"unmasked proc", containerContext("      procMount: Unmasked\n"), workspace.StorageVolume, true},
+		{"privileged port", restricted + "    ports:\n    - containerPort: 80\n", workspace.StorageVolume, true},
+		{"unprivileged port", restricted + "    ports:\n    - containerPort: 8080\n", workspace.StorageVolume, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckPodSecurity([]byte(tt.manifest), tt.backend)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckPodSecurity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
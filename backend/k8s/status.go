@@ -0,0 +1,369 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// cacheSyncTimeout bounds how long Start waits for the first pod listing
+const cacheSyncTimeout = 30 * time.Second
+
+// PodStatus is the last known state of a workspace's pod. Reason and Message explain why
+// it isn't running, e.g. ImagePullBackOff or Unschedulable; EventReason and Event are the
+// pod's latest Kubernetes event, e.g. Pulling. Deleted is set once the pod is gone.
+type PodStatus struct {
+	WorkspaceID string          `json:"workspaceId"`
+	Pod         string          `json:"pod"`
+	Phase       corev1.PodPhase `json:"phase"`
+	Scheduled   bool            `json:"scheduled"`
+	Ready       bool            `json:"ready"`
+	Restarts    int32           `json:"restarts"`
+	Reason      string          `json:"reason,omitempty"`
+	Message     string          `json:"message,omitempty"`
+	EventReason string          `json:"eventReason,omitempty"`
+	Event       string          `json:"event,omitempty"`
+	Deleted     bool            `json:"deleted,omitempty"`
+}
+
+// podRef is a pod the informer has seen; events about an older pod of the same name are ignored
+type podRef struct {
+	workspaceId string
+	uid         types.UID
+}
+
+// podEvent is the latest Kubernetes event about a pod
+type podEvent struct {
+	reason  string
+	message string
+}
+
+// fatalReasons are waiting reasons a pod doesn't recover from without a new manifest. Failed
+// image pulls aren't among them: the kubelet retries them and a registry hiccup clears up,
+// so WaitReady only gives up on them after maxPullBackOffs
+var fatalReasons = map[string]bool{
+	"InvalidImageName":           true,
+	"ErrImageNeverPull":          true,
+	"CreateContainerConfigError": true,
+	"CrashLoopBackOff":           true,
+}
+
+// maxPullBackOffs is how many times WaitReady sees the kubelet back off pulling the image
+// before deciding it won't turn up
+const maxPullBackOffs = 5
+
+// StatusController tracks the pod of every workspace in the namespace, and the events about
+// those pods, through shared informers, so callers read status from memory instead of
+// opening their own watches
+type StatusController struct {
+	factories []informers.SharedInformerFactory
+	synced    []cache.InformerSynced
+
+	mu       sync.RWMutex
+	statuses map[string]PodStatus
+	// pods maps a pod name to the pod, events is the latest event of each of those pods
+	pods   map[string]podRef
+	events map[string]podEvent
+	subs   map[string]map[chan PodStatus]struct{}
+}
+
+func NewStatusController(kube kubernetes.Interface) *StatusController {
+	podFactory := informers.NewSharedInformerFactoryWithOptions(kube, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = LabelWorkspace
+		}))
+	//events carry no labels, so they need a factory of their own:
+	eventFactory := informers.NewSharedInformerFactoryWithOptions(kube, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = "involvedObject.kind=Pod"
+		}))
+
+	c := &StatusController{
+		factories: []informers.SharedInformerFactory{podFactory, eventFactory},
+		statuses:  make(map[string]PodStatus),
+		pods:      make(map[string]podRef),
+		events:    make(map[string]podEvent),
+		subs:      make(map[string]map[chan PodStatus]struct{}),
+	}
+
+	podInformer := podFactory.Core().V1().Pods().Informer()
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.podChanged,
+		UpdateFunc: func(_, obj interface{}) { c.podChanged(obj) },
+		DeleteFunc: c.podDeleted,
+	})
+	eventInformer := eventFactory.Core().V1().Events().Informer()
+	eventInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.eventChanged,
+		UpdateFunc: func(_, obj interface{}) { c.eventChanged(obj) },
+	})
+	c.synced = []cache.InformerSynced{podInformer.HasSynced, eventInformer.HasSynced}
+	return c
+}
+
+// Start runs the informer until ctx is done and waits for its first listing
+func (c *StatusController) Start(ctx context.Context) error {
+	for _, factory := range c.factories {
+		factory.Start(ctx.Done())
+	}
+
+	syncCtx, cancel := context.WithTimeout(ctx, cacheSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), c.synced...) {
+		return fmt.Errorf("timed out listing workspace pods")
+	}
+	return nil
+}
+
+// Get returns the status of a workspace's pod; false when it has none
+func (c *StatusController) Get(workspaceId string) (PodStatus, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	status, ok := c.statuses[workspaceId]
+	return status, ok
+}
+
+// Subscribe streams every status change of a workspace until ctx is done. Updates are
+// dropped while the receiver is behind, so use Get for the current state.
+func (c *StatusController) Subscribe(ctx context.Context, workspaceId string) <-chan PodStatus {
+	ch := make(chan PodStatus, 16)
+
+	c.mu.Lock()
+	if c.subs[workspaceId] == nil {
+		c.subs[workspaceId] = make(map[chan PodStatus]struct{})
+	}
+	c.subs[workspaceId][ch] = struct{}{}
+	c.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		c.mu.Lock()
+		delete(c.subs[workspaceId], ch)
+		if len(c.subs[workspaceId]) == 0 {
+			delete(c.subs, workspaceId)
+		}
+		c.mu.Unlock()
+		close(ch)
+	}()
+	return ch
+}
+
+// Wait blocks until the workspace's status satisfies cond; cond returning an error stops waiting
+func (c *StatusController) Wait(ctx context.Context, workspaceId string, cond func(PodStatus, bool) (bool, error)) (PodStatus, error) {
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	updates := c.Subscribe(subCtx, workspaceId)
+
+	for {
+		//updates can be dropped, so always check the latest status:
+		status, ok := c.Get(workspaceId)
+		done, err := cond(status, ok)
+		if err != nil {
+			return status, err
+		}
+		if done {
+			return status, nil
+		}
+
+		select {
+		case <-updates:
+		case <-ctx.Done():
+			return status, ctx.Err()
+		}
+	}
+}
+
+// WaitReady waits up to timeout for the workspace's pod to become ready, giving up early
+// when the pod has stopped or can't start for good. progress, when set, sees every status
+// observed on the way.
+func (c *StatusController) WaitReady(ctx context.Context, workspaceId string, timeout time.Duration, progress func(PodStatus)) (PodStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastReason string
+	var backOffs int
+	status, err := c.Wait(ctx, workspaceId, func(status PodStatus, ok bool) (bool, error) {
+		if !ok {
+			return false, nil
+		}
+		if progress != nil {
+			progress(status)
+		}
+		if status.Phase == corev1.PodFailed || status.Phase == corev1.PodSucceeded {
+			return false, fmt.Errorf("pod %s stopped: %s", status.Pod, describe(status))
+		}
+		if fatalReasons[status.Reason] {
+			return false, fmt.Errorf("pod %s can't start: %s", status.Pod, describe(status))
+		}
+		if status.Reason == "ImagePullBackOff" && lastReason != status.Reason {
+			backOffs++
+			if backOffs >= maxPullBackOffs {
+				return false, fmt.Errorf("pod %s can't pull its image after %d attempts: %s", status.Pod, backOffs, describe(status))
+			}
+		}
+		lastReason = status.Reason
+		return status.Ready, nil
+	})
+	if err == context.DeadlineExceeded {
+		return status, fmt.Errorf("timeout waiting for workspace %s: %s", workspaceId, describe(status))
+	}
+	return status, err
+}
+
+// describe is the reason a pod isn't ready with its latest event, for error messages
+func describe(status PodStatus) string {
+	var reason string
+	switch {
+	case status.Pod == "":
+		return "no pod yet"
+	case status.Message != "":
+		reason = fmt.Sprintf("%s: %s", status.Reason, status.Message)
+	case status.Reason != "":
+		reason = status.Reason
+	default:
+		reason = string(status.Phase)
+	}
+	if status.Event != "" && status.Event != status.Message {
+		reason += fmt.Sprintf(" (last event %s: %s)", status.EventReason, status.Event)
+	}
+	return reason
+}
+
+func (c *StatusController) podChanged(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	id := pod.Labels[LabelWorkspace]
+	status := podStatus(id, pod)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pods[pod.Name] = podRef{workspaceId: id, uid: pod.UID}
+	if ev, ok := c.events[pod.Name]; ok {
+		status.EventReason, status.Event = ev.reason, ev.message
+	}
+	c.update(status)
+}
+
+func (c *StatusController) eventChanged(obj interface{}) {
+	event, ok := obj.(*corev1.Event)
+	if !ok {
+		return
+	}
+	name := event.InvolvedObject.Name
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	//only pods seen by the pod informer belong to workspaces:
+	ref, ok := c.pods[name]
+	if !ok || (event.InvolvedObject.UID != "" && event.InvolvedObject.UID != ref.uid) {
+		return
+	}
+	c.events[name] = podEvent{reason: event.Reason, message: event.Message}
+
+	status, ok := c.statuses[ref.workspaceId]
+	if !ok || status.Pod != name {
+		return
+	}
+	status.EventReason, status.Event = event.Reason, event.Message
+	c.update(status)
+}
+
+// update stores a status and notifies subscribers when it changed; the caller holds mu
+func (c *StatusController) update(status PodStatus) {
+	current, exists := c.statuses[status.WorkspaceID]
+	//while a replacement pod starts, the terminating one mustn't hide it:
+	if exists && current.Pod != status.Pod && status.Reason == "Terminating" {
+		return
+	}
+	if exists && current == status {
+		return
+	}
+	c.statuses[status.WorkspaceID] = status
+	c.notify(status)
+}
+
+func (c *StatusController) podDeleted(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	id := pod.Labels[LabelWorkspace]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pods, pod.Name)
+	delete(c.events, pod.Name)
+	current, exists := c.statuses[id]
+	if !exists || current.Pod != pod.Name {
+		return
+	}
+	delete(c.statuses, id)
+	current.Ready = false
+	current.Deleted = true
+	c.notify(current)
+}
+
+// notify sends to the workspace's subscribers without blocking; the caller holds mu
+func (c *StatusController) notify(status PodStatus) {
+	for ch := range c.subs[status.WorkspaceID] {
+		select {
+		case ch <- status:
+		default:
+		}
+	}
+}
+
+// podStatus summarizes a pod, preferring container level reasons (image pulls, crashes)
+// over pod level ones (scheduling)
+func podStatus(workspaceId string, pod *corev1.Pod) PodStatus {
+	status := PodStatus{
+		WorkspaceID: workspaceId,
+		Pod:         pod.Name,
+		Phase:       pod.Status.Phase,
+		Ready:       isPodReady(pod) && pod.DeletionTimestamp == nil,
+	}
+
+	for _, cs := range pod.Status.ContainerStatuses {
+		status.Restarts += cs.RestartCount
+		if status.Reason != "" || cs.Ready {
+			continue
+		}
+		if waiting := cs.State.Waiting; waiting != nil && waiting.Reason != "" {
+			status.Reason, status.Message = waiting.Reason, waiting.Message
+		} else if terminated := cs.State.Terminated; terminated != nil {
+			status.Reason, status.Message = terminated.Reason, terminated.Message
+		}
+	}
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type != corev1.PodScheduled {
+			continue
+		}
+		status.Scheduled = cond.Status == corev1.ConditionTrue
+		if !status.Scheduled && status.Reason == "" {
+			status.Reason, status.Message = cond.Reason, cond.Message
+		}
+	}
+	if status.Reason == "" && pod.Status.Reason != "" {
+		status.Reason, status.Message = pod.Status.Reason, pod.Status.Message
+	}
+	if pod.DeletionTimestamp != nil {
+		status.Reason, status.Message = "Terminating", ""
+	}
+	return status
+}
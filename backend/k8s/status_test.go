@@ -0,0 +1,147 @@
+package k8s
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// waitingPod is a scheduled workspace pod whose container waits with reason
+func waitingPod(reason string, message string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "ws1-0", Labels: map[string]string{LabelWorkspace: "ws1"}},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodPending,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionTrue}},
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "workspace",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: message}},
+			}},
+		},
+	}
+}
+
+// TestWaitReadyFatalReasons checks a wait fails as soon as the pod shows a reason it won't
+// start with, long before its timeout
+func TestWaitReadyFatalReasons(t *testing.T) {
+	for _, reason := range []string{"InvalidImageName", "ErrImageNeverPull", "CrashLoopBackOff"} {
+		t.Run(reason, func(t *testing.T) {
+			c := NewStatusController(fake.NewSimpleClientset())
+			c.podChanged(waitingPod(reason, "no such image"))
+
+			start := time.Now()
+			var seen []string
+			_, err := c.WaitReady(context.Background(), "ws1", 5*time.Second, func(status PodStatus) {
+				seen = append(seen, status.Reason)
+			})
+			if err == nil || !strings.Contains(err.Error(), reason) {
+				t.Fatalf("WaitReady() error = %v, want one naming %s", err, reason)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("WaitReady() took %v, want an immediate failure", elapsed)
+			}
+			if len(seen) != 1 || seen[0] != reason {
+				t.Errorf("progress saw %v, want [%s]", seen, reason)
+			}
+		})
+	}
+}
+
+// TestWaitReadyPending checks a pod still being scheduled is waited on until the timeout
+func TestWaitReadyPending(t *testing.T) {
+	c := NewStatusController(fake.NewSimpleClientset())
+	c.podChanged(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "ws1-0", Labels: map[string]string{LabelWorkspace: "ws1"}},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "workspace",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+			}},
+		},
+	})
+
+	_, err := c.WaitReady(context.Background(), "ws1", 50*time.Millisecond, nil)
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("WaitReady() error = %v, want a timeout", err)
+	}
+}
+
+// TestWaitReadyPullRetries checks failed image pulls are waited out while the kubelet retries
+// them, and the wait fails naming the pull error once it has backed off maxPullBackOffs times
+func TestWaitReadyPullRetries(t *testing.T) {
+	c := NewStatusController(fake.NewSimpleClientset())
+	c.podChanged(waitingPod("ErrImagePull", "registry unavailable"))
+
+	_, err := c.WaitReady(context.Background(), "ws1", 50*time.Millisecond, nil)
+	if err == nil || !strings.Contains(err.Error(), "timeout") || !strings.Contains(err.Error(), "registry unavailable") {
+		t.Fatalf("WaitReady() error = %v, want a timeout naming the pull error", err)
+	}
+
+	done := make(chan error, 1)
+	var mu sync.Mutex
+	var seen []string
+	go func() {
+		_, err := c.WaitReady(context.Background(), "ws1", 5*time.Second, func(status PodStatus) {
+			mu.Lock()
+			seen = append(seen, status.Reason)
+			mu.Unlock()
+		})
+		done <- err
+	}()
+	//the kubelet alternating between pull attempts and backing off:
+	for i := 0; i < maxPullBackOffs; i++ {
+		for _, reason := range []string{"ImagePullBackOff", "ErrImagePull"} {
+			time.Sleep(10 * time.Millisecond)
+			c.podChanged(waitingPod(reason, "registry unavailable"))
+		}
+	}
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "ImagePullBackOff") {
+			t.Fatalf("WaitReady() error = %v, want one naming ImagePullBackOff", err)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatal("WaitReady() kept waiting on an image that never pulls")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) == 0 || seen[0] != "ErrImagePull" {
+		t.Errorf("progress saw %v, want the pull errors", seen)
+	}
+}
+
+// TestWaitReadyPullRecovers checks a pod whose first pulls fail still comes up
+func TestWaitReadyPullRecovers(t *testing.T) {
+	c := NewStatusController(fake.NewSimpleClientset())
+	c.podChanged(waitingPod("ImagePullBackOff", "registry unavailable"))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.WaitReady(context.Background(), "ws1", 5*time.Second, nil)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	pod := waitingPod("", "")
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.Conditions = append(pod.Status.Conditions, corev1.PodCondition{Type: corev1.PodReady, Status: corev1.ConditionTrue})
+	pod.Status.ContainerStatuses[0].State = corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	pod.Status.ContainerStatuses[0].Ready = true
+	c.podChanged(pod)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WaitReady() error = %v, want the pod ready", err)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatal("WaitReady() didn't see the pod become ready")
+	}
+}
@@ -1,146 +1,311 @@
 package k8s
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"text/template"
+
+	"github.com/mudit06mah/CloudIde/catalog"
+	"github.com/mudit06mah/CloudIde/k8s/manifests"
+	"github.com/mudit06mah/CloudIde/workspace"
+	"k8s.io/apimachinery/pkg/api/resource"
+	kjson "k8s.io/apimachinery/pkg/runtime/serializer/json"
+	kyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
 )
 
-type ResourceTemplate struct {
-	templatePath string
-	variables    map[string]string
+// RenderOptions are the per-workspace inputs of RenderManifests
+type RenderOptions struct {
+	WorkspaceID string
+	Storage     workspace.Storage
+	// Profile sizes the shell container; a zero Profile leaves resources to the namespace LimitRange
+	Profile catalog.Profile
 }
 
-type ProjectTemplate struct {
-	image     string
-	resources map[string]ResourceTemplate
+// RenderProjectResources renders every manifest listed for a project type in the catalogue
+// for opts.WorkspaceID. It keeps no state between calls, so sessions can render concurrently.
+func RenderProjectResources(projectType catalog.ProjectType, opts RenderOptions) ([][]byte, error) {
+	if opts.WorkspaceID == "" {
+		return nil, fmt.Errorf("no workspace to render %s for", projectType.Name)
+	}
+	return RenderManifests(projectType, opts)
 }
 
-var ProjectTemplateConfig = map[string]ProjectTemplate{
-	"cpp": {
-		image: "ghcr.io/mudit06mah/shell-cpp:latest",
-		resources: map[string]ResourceTemplate{
-			"shellPod": {
-				templatePath: "./k8s/manifests/shell-pod.yaml",
-				variables: map[string]string{
-					"SHELL_IMAGE": "ghcr.io/mudit06mah/shell-cpp:latest",
-				},
-			},
-		},
-	},
-	"python": {
-		image: "ghcr.io/mudit06mah/shell-python:latest",
-		resources: map[string]ResourceTemplate{
-			"shellPod": {
-				templatePath: "./k8s/manifests/shell-pod.yaml",
-				variables: map[string]string{
-					"SHELL_IMAGE": "ghcr.io/mudit06mah/shell-python:latest",
-				},
-			},
-		},
-	},
-	"nodejs": {
-		image: "ghcr.io/mudit06mah/shell-nodejs:latest",
-		resources: map[string]ResourceTemplate{
-			"shellPod": {
-				templatePath: "./k8s/manifests/shell-pod.yaml",
-				variables: map[string]string{
-					"SHELL_IMAGE": "ghcr.io/mudit06mah/shell-nodejs:latest",
-				},
-			},
-		},
-	},
-	"golang": {
-		image: "ghcr.io/mudit06mah/shell-golang:latest",
-		resources: map[string]ResourceTemplate{
-			"shellPod": {
-				templatePath: "./k8s/manifests/shell-pod.yaml",
-				variables: map[string]string{
-					"SHELL_IMAGE": "ghcr.io/mudit06mah/shell-golang:latest",
-				},
-			},
-		},
-	},
-	"react": {
-		image: "ghcr.io/mudit06mah/shell-nodejs:latest",
-		resources: map[string]ResourceTemplate{
-			"shellPod": {
-				templatePath: "./k8s/manifests/shell-pod-react.yaml",
-				variables: map[string]string{
-					"SHELL_IMAGE": "ghcr.io/mudit06mah/shell-nodejs:latest",
-				},
-			},
-			"service": {
-				templatePath: "./k8s/manifests/service.yaml",
-				variables:    map[string]string{},
-			},
-			"ingress": {
-				templatePath: "./k8s/manifests/ingress.yaml",
-				variables: map[string]string{
-					"HOST": "",
-				},
-			},
-		},
-	},
-}
+// defaultDNSServers resolve names for workspace pods unless WORKSPACE_DNS_SERVERS overrides them
+var defaultDNSServers = []string{"8.8.8.8", "1.1.1.1"}
 
-func getProjectConfig(projectType string) (ProjectTemplate, error) {
-	config, exists := ProjectTemplateConfig[projectType]
-	if !exists {
-		return ProjectTemplate{}, fmt.Errorf("Unsupported project type: %s", projectType)
+// dnsServers reads the comma separated WORKSPACE_DNS_SERVERS; the NetworkPolicy only lets
+// workspaces reach these on port 53
+func dnsServers() ([]string, error) {
+	env := os.Getenv("WORKSPACE_DNS_SERVERS")
+	if env == "" {
+		return defaultDNSServers, nil
+	}
+	var servers []string
+	for _, server := range strings.Split(env, ",") {
+		server = strings.TrimSpace(server)
+		if ip := net.ParseIP(server); ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid WORKSPACE_DNS_SERVERS entry %q: expected an IPv4 address", server)
+		}
+		servers = append(servers, server)
 	}
-	return config, nil
+	return servers, nil
 }
 
-func (c *Client) RenderProjectResources(projectType string) ([][]byte, error) {
-	projectTemplate, err := getProjectConfig(projectType)
-
+// RenderManifests renders a project type's manifests for a workspace without needing a cluster,
+// skipping manifests meant for another storage backend. Unless the type's network mode is open,
+// a NetworkPolicy isolating the workspace is rendered after them.
+func RenderManifests(projectType catalog.ProjectType, opts RenderOptions) ([][]byte, error) {
+	workspaceId, storage := opts.WorkspaceID, opts.Storage
+	dns, err := dnsServers()
 	if err != nil {
 		return nil, err
 	}
-
 	var commonVars = map[string]string{
-		"WORKSPACE_ID": workspaceId,
-		"NAMESPACE":    namespace,
+		"WORKSPACE_ID":  workspaceId,
+		"NAMESPACE":     namespace,
+		"SHELL_IMAGE":   projectType.Image,
+		"STORAGE_SIZE":  storage.Size,
+		"STORAGE_CLASS": storage.Class,
+		// an empty image leaves the file agent sidecar out
+		"FILE_AGENT_IMAGE": storage.Agent.Image,
+		"FILE_AGENT_TOKEN": storage.Agent.Token(workspaceId),
+		// an empty runtime class uses the cluster default
+		"RUNTIME_CLASS_NAME": projectType.RuntimeClassName,
+		// the shell user's home folder, mounted writable over the read-only image; types may override it
+		"HOME_DIR": "/home/dev",
 	}
 
 	var manifestRender [][]byte
 
-	for resourceName, resourceTemplate := range projectTemplate.resources {
-		allVars := make(map[string]string)
+	for _, resourceTemplate := range projectType.Manifests {
+		if resourceTemplate.Storage != "" && resourceTemplate.Storage != storage.Backend {
+			continue
+		}
+
+		allVars := make(map[string]interface{})
 
 		for k, v := range commonVars {
 			allVars[k] = v
 		}
 
-		for k, v := range resourceTemplate.variables {
+		for k, v := range projectType.Variables {
 			allVars[k] = v
 		}
 
-		if resourceName == "ingress" {
-			allVars["HOST"] = workspaceId + ".localhost"
+		for k, v := range resourceTemplate.Variables {
+			allVars[k] = v
 		}
 
-		manifest, err := RenderTemplate(resourceTemplate.templatePath, allVars)
+		allVars["Ports"] = projectType.Ports
+		allVars["Resources"] = profileResources(opts.Profile)
+		allVars["DNS_SERVERS"] = dns
+
+		manifest, err := RenderTemplate(resourceTemplate.Path, allVars)
 		if err != nil {
 			return nil, err
 		}
 
+		if err := ValidateManifest(manifest); err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %v", resourceTemplate.Name, err)
+		}
+
+		if err := CheckPodSecurity(manifest, storage.Backend); err != nil {
+			return nil, fmt.Errorf("manifest %s: %v", resourceTemplate.Name, err)
+		}
+
 		manifestRender = append(manifestRender, manifest)
 
 	}
 
+	if projectType.Network.Mode != "" && projectType.Network.Mode != catalog.NetworkOpen {
+		//the pods allowed in by label, the backend's, rarely share the workspaces' namespace:
+		podNamespace := projectType.Network.AllowFromPodNamespace
+		if podNamespace == "" {
+			podNamespace = os.Getenv("POD_NAMESPACE")
+		}
+		if podNamespace == "" {
+			podNamespace = namespace
+		}
+		manifest, err := RenderTemplate("networkpolicy.yaml", map[string]interface{}{
+			"WORKSPACE_ID":  workspaceId,
+			"NAMESPACE":     namespace,
+			"POD_NAMESPACE": podNamespace,
+			"DNS_SERVERS":   dns,
+			"Network":       projectType.Network,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := ValidateManifest(manifest); err != nil {
+			return nil, fmt.Errorf("invalid network policy: %v", err)
+		}
+		manifestRender = append(manifestRender, manifest)
+	}
+
 	return manifestRender, nil
 }
 
-func RenderTemplate(templatePath string, replace map[string]string) ([]byte, error) {
-	yaml, err := os.ReadFile(templatePath)
+// profileResources is a profile as a container's resources block, nil when it sets nothing
+func profileResources(profile catalog.Profile) map[string]map[string]string {
+	resources := make(map[string]map[string]string)
+	if len(profile.Requests) > 0 {
+		resources["requests"] = profile.Requests
+	}
+	if len(profile.Limits) > 0 {
+		resources["limits"] = profile.Limits
+	}
+	if len(resources) == 0 {
+		return nil
+	}
+	return resources
+}
+
+// RenderNamespacePolicy renders the namespace ResourceQuota (when the catalogue sets one) and a
+// LimitRange defaulting containers to the default profile and capping them at the largest one
+func RenderNamespacePolicy(projectCatalog *catalog.Catalog) ([][]byte, error) {
+	var manifests [][]byte
+
+	if quota := projectCatalog.Quota(); len(quota) > 0 {
+		manifest, err := RenderTemplate("quota.yaml", map[string]interface{}{"NAMESPACE": namespace, "Hard": quota})
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	defaultProfile, ok := projectCatalog.Profile(projectCatalog.DefaultProfile())
+	if ok {
+		max := make(map[string]string)
+		for _, profile := range projectCatalog.Profiles() {
+			for name, value := range profile.Limits {
+				limit := resource.MustParse(value)
+				if current, ok := max[name]; !ok || limit.Cmp(resource.MustParse(current)) > 0 {
+					max[name] = value
+				}
+			}
+		}
+
+		manifest, err := RenderTemplate("limitrange.yaml", map[string]interface{}{
+			"NAMESPACE":      namespace,
+			"Default":        defaultProfile.Limits,
+			"DefaultRequest": defaultProfile.Requests,
+			"Max":            max,
+		})
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	for _, manifest := range manifests {
+		if err := ValidateManifest(manifest); err != nil {
+			return nil, fmt.Errorf("invalid namespace policy: %v", err)
+		}
+	}
+	return manifests, nil
+}
+
+// ApplyNamespacePolicy creates or updates the namespace ResourceQuota and LimitRange. The
+// backend's Role must allow get, create and patch on both in that namespace, see rbac.yaml.
+func (c *Client) ApplyNamespacePolicy(ctx context.Context, projectCatalog *catalog.Catalog) error {
+	manifests, err := RenderNamespacePolicy(projectCatalog)
+	if err != nil {
+		return err
+	}
+	for _, manifest := range manifests {
+		if _, err := c.ApplyManifest(ctx, manifest, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateFuncs are the helpers available inside manifest templates
+var templateFuncs = template.FuncMap{
+	// quote renders a string as a double quoted YAML scalar
+	"quote": func(v interface{}) (string, error) {
+		out, err := json.Marshal(fmt.Sprint(v))
+		return string(out), err
+	},
+	// default returns def when val is empty. Templates render with missingkey=error, so an
+	// optional variable is looked up with index: {{ index . "X" | default "y" }}
+	"default": func(def interface{}, val interface{}) interface{} {
+		if val == nil || fmt.Sprint(val) == "" {
+			return def
+		}
+		return val
+	},
+	"toYaml": func(v interface{}) (string, error) {
+		out, err := yaml.Marshal(v)
+		return strings.TrimSuffix(string(out), "\n"), err
+	},
+	"indent": func(spaces int, v string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.ReplaceAll(v, "\n", "\n"+pad)
+	},
+	"nindent": func(spaces int, v string) string {
+		pad := strings.Repeat(" ", spaces)
+		return "\n" + pad + strings.ReplaceAll(v, "\n", "\n"+pad)
+	},
+}
+
+// RenderTemplate executes a manifest template; referencing a variable that wasn't provided is an error
+func RenderTemplate(templatePath string, data map[string]interface{}) ([]byte, error) {
+	raw, err := manifests.Read(templatePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read template file: %v", err)
 	}
-	for key, value := range replace {
-		yaml = []byte(strings.ReplaceAll(string(yaml), "{{"+key+"}}", value))
+
+	tmpl, err := template.New(filepath.Base(templatePath)).
+		Option("missingkey=error").
+		Funcs(templateFuncs).
+		Parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %v", templatePath, err)
 	}
 
-	return yaml, nil
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return nil, fmt.Errorf("failed to render template %s: %v", templatePath, err)
+	}
+
+	return out.Bytes(), nil
+}
+
+// strictDecoder decodes YAML into typed objects, rejecting unknown or duplicate fields
+var strictDecoder = kjson.NewSerializerWithOptions(kjson.DefaultMetaFactory, scheme.Scheme, scheme.Scheme,
+	kjson.SerializerOptions{Yaml: true, Strict: true})
+
+// ValidateManifest decodes every document of a rendered manifest into its typed Kubernetes object
+func ValidateManifest(manifest []byte) error {
+	reader := kyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(manifest)))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to split manifest: %v", err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		if _, gvk, err := strictDecoder.Decode(doc, nil, nil); err != nil {
+			if gvk != nil {
+				return fmt.Errorf("%s: %v", gvk.Kind, err)
+			}
+			return err
+		}
+	}
 }
@@ -0,0 +1,157 @@
+package k8s
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/mudit06mah/CloudIde/catalog"
+	"github.com/mudit06mah/CloudIde/workspace"
+	networkingv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// TestRenderConcurrentWorkspaces renders manifests for many workspaces at once, as concurrent
+// sessions do, and checks no workspace's manifests mention another's id. Run it with -race.
+func TestRenderConcurrentWorkspaces(t *testing.T) {
+	projectCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectTypes := projectCatalog.List()
+	if len(projectTypes) == 0 {
+		t.Fatal("empty catalogue")
+	}
+
+	const workspaces = 16
+	var ids []string
+	for i := 0; i < workspaces; i++ {
+		ids = append(ids, fmt.Sprintf("ws%02d", i))
+	}
+
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			projectType := projectTypes[i%len(projectTypes)]
+			storage := workspace.Storage{Backend: workspace.StorageHostPath}
+			for round := 0; round < 10; round++ {
+				manifests, err := RenderProjectResources(projectType, RenderOptions{WorkspaceID: id, Storage: storage})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				for _, manifest := range manifests {
+					if !bytes.Contains(manifest, []byte(id)) {
+						t.Errorf("%s: manifest doesn't mention its workspace:\n%s", id, manifest)
+					}
+					for _, other := range ids {
+						if other != id && bytes.Contains(manifest, []byte(other)) {
+							t.Errorf("%s: manifest mentions workspace %s:\n%s", id, other, manifest)
+						}
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRenderProjectResourcesNeedsWorkspace(t *testing.T) {
+	projectCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectType := projectCatalog.List()[0]
+	if _, err := RenderProjectResources(projectType, RenderOptions{}); err == nil {
+		t.Error("rendered manifests without a workspace id")
+	}
+}
+
+// renderNetworkPolicy renders projectType's manifests and returns its NetworkPolicy
+func renderNetworkPolicy(t *testing.T, projectType catalog.ProjectType) networkingv1.NetworkPolicy {
+	t.Helper()
+	manifests, err := RenderProjectResources(projectType, RenderOptions{
+		WorkspaceID: "ws01",
+		Storage:     workspace.Storage{Backend: workspace.StorageHostPath},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, manifest := range manifests {
+		var policy networkingv1.NetworkPolicy
+		if err := yaml.Unmarshal(manifest, &policy); err != nil {
+			t.Fatal(err)
+		}
+		if policy.Kind == "NetworkPolicy" {
+			return policy
+		}
+	}
+	t.Fatalf("%s: no NetworkPolicy rendered", projectType.Name)
+	return networkingv1.NetworkPolicy{}
+}
+
+// allowsPeer reports whether one ingress peer selects pods labelled app in namespace, both
+// selectors in the same entry so that they're ANDed
+func allowsPeer(policy networkingv1.NetworkPolicy, namespace string, app string) bool {
+	for _, rule := range policy.Spec.Ingress {
+		for _, peer := range rule.From {
+			if peer.NamespaceSelector == nil || peer.PodSelector == nil {
+				continue
+			}
+			if peer.NamespaceSelector.MatchLabels["kubernetes.io/metadata.name"] == namespace &&
+				peer.PodSelector.MatchLabels["app"] == app {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestRenderNetworkPolicyAllowsBackend(t *testing.T) {
+	projectCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rendered int
+	for _, projectType := range projectCatalog.List() {
+		if projectType.Network.Mode == "" || projectType.Network.Mode == catalog.NetworkOpen {
+			continue
+		}
+		rendered++
+		policy := renderNetworkPolicy(t, projectType)
+		if !allowsPeer(policy, "kube-system", "cloud-ide-backend") {
+			t.Errorf("%s: backend in kube-system not allowed in:\n%+v", projectType.Name, policy.Spec.Ingress)
+		}
+	}
+	if rendered == 0 {
+		t.Fatal("no project type renders a NetworkPolicy")
+	}
+}
+
+func TestRenderNetworkPolicyPodNamespace(t *testing.T) {
+	projectCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var projectType catalog.ProjectType
+	for _, pt := range projectCatalog.List() {
+		if pt.Network.Mode != "" && pt.Network.Mode != catalog.NetworkOpen {
+			projectType = pt
+			break
+		}
+	}
+	projectType.Network.AllowFromPodNamespace = ""
+
+	t.Setenv("POD_NAMESPACE", "ide-system")
+	if policy := renderNetworkPolicy(t, projectType); !allowsPeer(policy, "ide-system", "cloud-ide-backend") {
+		t.Errorf("backend in POD_NAMESPACE not allowed in:\n%+v", policy.Spec.Ingress)
+	}
+
+	t.Setenv("POD_NAMESPACE", "")
+	if policy := renderNetworkPolicy(t, projectType); !allowsPeer(policy, Namespace, "cloud-ide-backend") {
+		t.Errorf("backend in the workspace namespace not allowed in:\n%+v", policy.Spec.Ingress)
+	}
+}
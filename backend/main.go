@@ -1,13 +1,249 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mudit06mah/CloudIde/auth"
 	"github.com/mudit06mah/CloudIde/aws"
-	"github.com/mudit06mah/CloudIde/ws"
+	"github.com/mudit06mah/CloudIde/catalog"
 	"github.com/mudit06mah/CloudIde/config"
+	"github.com/mudit06mah/CloudIde/k8s"
+	"github.com/mudit06mah/CloudIde/templates"
+	"github.com/mudit06mah/CloudIde/workspace"
+	"github.com/mudit06mah/CloudIde/ws"
 )
 
 func main() {
 	config.LoadEnv()
+
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := runRender(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		if err := runToken(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	aws.InitAWSConfig()
-	ws.StartWebSocketServer()
-}	
\ No newline at end of file
+
+	authenticator, err := auth.NewFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure authentication, %v", err)
+	}
+
+	store, err := workspace.NewStoreFromEnv()
+	if err != nil {
+		log.Fatalf("failed to open workspace store, %v", err)
+	}
+	defer store.Close()
+
+	storage, err := workspace.StorageFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure workspace storage, %v", err)
+	}
+
+	templateSource, err := newTemplateSource()
+	if err != nil {
+		log.Fatalf("failed to configure templates, %v", err)
+	}
+
+	//CATALOG_PATH overrides the built-in project types and is reloaded when it changes:
+	projectCatalog, err := catalog.Load(os.Getenv("CATALOG_PATH"))
+	if err != nil {
+		log.Fatalf("failed to load project catalogue, %v", err)
+	}
+
+	//one client for the whole process; without a cluster the server still starts but can't run workspaces:
+	client, err := k8s.NewK8sClient()
+	if err != nil {
+		log.Println("WARNING: no Kubernetes cluster, workspaces can't be started:", err)
+		client = nil
+	}
+
+	server := &ws.Server{
+		Auth:      authenticator,
+		Store:     store,
+		Activity:  workspace.NewActivity(),
+		Templates: templateSource,
+		Catalog:   projectCatalog,
+		Storage:   storage,
+		K8s:       client,
+	}
+	startCluster(server)
+	go projectCatalog.Watch(context.Background(), 10*time.Second)
+
+	ws.StartWebSocketServer(server)
+}
+
+// newTemplateSource picks where starter templates come from via TEMPLATE_SOURCE:
+// s3 (default), local (TEMPLATE_DIR/<name>/) or embedded (compiled into the binary)
+func newTemplateSource() (templates.Source, error) {
+	source := os.Getenv("TEMPLATE_SOURCE")
+	switch source {
+	case "", "s3":
+		return aws.NewS3TemplateSource(), nil
+	case "local":
+		dir := os.Getenv("TEMPLATE_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("TEMPLATE_DIR must be set for local templates")
+		}
+		return templates.NewLocalSource(dir), nil
+	case "embedded":
+		return templates.NewEmbeddedSource(), nil
+	default:
+		return nil, fmt.Errorf("unsupported TEMPLATE_SOURCE: %s", source)
+	}
+}
+
+// startCluster applies the namespace quota and limits (again whenever the catalogue reloads),
+// starts the workspace status controller and runs the idle workspace reaper in the background.
+// All of it is skipped (with a warning) when no cluster is reachable so the server can still start.
+func startCluster(server *ws.Server) {
+	client, projectCatalog := server.K8s, server.Catalog
+	if client == nil {
+		log.Println("WARNING: namespace quota, workspace status and idle reaper disabled")
+		return
+	}
+
+	status := k8s.NewStatusController(client.Clientset)
+	if err := status.Start(context.Background()); err != nil {
+		//the informer keeps retrying in the background:
+		log.Println("WARNING: workspace status not synced yet:", err)
+	}
+	server.Status = status
+
+	applyPolicy := func(c *catalog.Catalog) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := client.ApplyNamespacePolicy(ctx, c); err != nil {
+			log.Println("Error applying namespace quota:", err)
+		}
+	}
+	applyPolicy(projectCatalog)
+	projectCatalog.OnReload = applyPolicy
+
+	reaper, err := workspace.NewReaperFromEnv(server.Store, server.Activity, client.Clientset, k8s.Namespace)
+	if err != nil {
+		log.Fatalf("failed to configure reaper, %v", err)
+	}
+	reaper.Collector = client
+	//expired workspaces are snapshotted and stopped the same way users stop them:
+	reaper.Stopper = server
+	go reaper.Run(context.Background())
+}
+
+// runRender prints the fully rendered manifests for a project type, or the namespace
+// quota and limits with -policy, for debugging:
+//
+//	backend render -type react -workspace abc123 -storage volume -plan medium
+//	backend render -policy
+func runRender(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	projectTypeName := flags.String("type", "", "project type to render")
+	workspaceId := flags.String("workspace", "preview", "workspace id to render with")
+	catalogPath := flags.String("catalog", os.Getenv("CATALOG_PATH"), "project catalogue (defaults to the built-in one)")
+	storageBackend := flags.String("storage", "", "storage backend to render for (defaults to WORKSPACE_STORAGE)")
+	plan := flags.String("plan", "", "resource profile to render with (defaults to the catalogue's default)")
+	policy := flags.Bool("policy", false, "render the namespace quota and limits instead of a project type")
+	flags.Parse(args)
+
+	if *projectTypeName == "" && !*policy {
+		flags.Usage()
+		return fmt.Errorf("-type is required")
+	}
+
+	projectCatalog, err := catalog.Load(*catalogPath)
+	if err != nil {
+		return err
+	}
+	if *policy {
+		manifests, err := k8s.RenderNamespacePolicy(projectCatalog)
+		if err != nil {
+			return err
+		}
+		printManifests(manifests)
+		return nil
+	}
+
+	projectType, ok := projectCatalog.Get(*projectTypeName)
+	if !ok {
+		return fmt.Errorf("unsupported project type: %s", *projectTypeName)
+	}
+
+	storage, err := workspace.StorageFromEnv()
+	if err != nil {
+		return err
+	}
+	if *storageBackend != "" {
+		storage.Backend = workspace.StorageBackend(*storageBackend)
+		if !storage.Backend.Valid() {
+			return fmt.Errorf("unsupported storage backend: %s", *storageBackend)
+		}
+	}
+
+	var profile catalog.Profile
+	if *plan == "" {
+		*plan = projectCatalog.DefaultProfile()
+	}
+	if *plan != "" {
+		if profile, ok = projectCatalog.Profile(*plan); !ok {
+			return fmt.Errorf("unknown plan: %s", *plan)
+		}
+	}
+
+	manifests, err := k8s.RenderManifests(projectType, k8s.RenderOptions{WorkspaceID: *workspaceId, Storage: storage, Profile: profile})
+	if err != nil {
+		return err
+	}
+	printManifests(manifests)
+	return nil
+}
+
+// runToken prints a token signed with AUTH_SECRET, e.g. for the frontend's VITE_AUTH_TOKEN:
+//
+//	backend token -user dev -ttl 720h -plans small,medium
+func runToken(args []string) error {
+	flags := flag.NewFlagSet("token", flag.ExitOnError)
+	user := flags.String("user", "dev", "user id the token authenticates")
+	ttl := flags.Duration("ttl", 24*time.Hour, "how long the token stays valid")
+	plans := flags.String("plans", "", "comma separated plans to grant (defaults to DEFAULT_PLANS)")
+	flags.Parse(args)
+
+	secret := os.Getenv("AUTH_SECRET")
+	if secret == "" {
+		return fmt.Errorf("AUTH_SECRET must be set to sign tokens")
+	}
+	var granted []string
+	for _, plan := range strings.Split(*plans, ",") {
+		if plan = strings.TrimSpace(plan); plan != "" {
+			granted = append(granted, plan)
+		}
+	}
+
+	token, err := auth.NewHMACAuthenticator([]byte(secret)).Sign(*user, *ttl, granted...)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+func printManifests(manifests [][]byte) {
+	for i, manifest := range manifests {
+		if i > 0 {
+			fmt.Println("---")
+		}
+		fmt.Println(strings.TrimSpace(string(manifest)))
+	}
+}
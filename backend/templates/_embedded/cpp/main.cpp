@@ -0,0 +1,6 @@
+#include <iostream>
+
+int main() {
+    std::cout << "Hello from CloudIDE!" << std::endl;
+    return 0;
+}
@@ -0,0 +1,6 @@
+def main():
+    print("Hello from CloudIDE!")
+
+
+if __name__ == "__main__":
+    main()
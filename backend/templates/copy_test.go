@@ -0,0 +1,57 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCopyDir(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{"main.go": "package main\n", "pkg/a/a.go": "package a\n", "empty": ""}
+	for name, content := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(src, "emptydir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/passwd", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "workspace")
+	if err := CopyDir(src, dest); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTree(t, dest); !reflect.DeepEqual(got, files) {
+		t.Errorf("copied %v, want %v", got, files)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "emptydir")); err != nil || !info.IsDir() {
+		t.Errorf("empty directory not copied: %v", err)
+	}
+	//symlinks could point out of the workspace, so they aren't copied:
+	if _, err := os.Lstat(filepath.Join(dest, "link")); !os.IsNotExist(err) {
+		t.Errorf("symlink copied (%v)", err)
+	}
+
+	//the copy is independent of the source, unlike a hardlink:
+	if err := os.WriteFile(filepath.Join(dest, "main.go"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(src, "main.go")); string(data) != files["main.go"] {
+		t.Errorf("editing the copy changed the source: %q", data)
+	}
+}
+
+func TestCopyDirMissingSource(t *testing.T) {
+	if err := CopyDir(filepath.Join(t.TempDir(), "missing"), t.TempDir()); err == nil {
+		t.Error("copying a missing directory succeeded")
+	}
+}
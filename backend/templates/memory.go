@@ -0,0 +1,40 @@
+package templates
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing/fstest"
+)
+
+// MemorySource serves templates held in memory, for tests and callers that generate them:
+// Templates maps a template name to its files, keyed by slash separated path
+type MemorySource struct {
+	Templates map[string]map[string]string
+
+	mu      sync.Mutex
+	fetched []string
+}
+
+func (m *MemorySource) Fetch(ctx context.Context, name string, dest string) error {
+	m.mu.Lock()
+	m.fetched = append(m.fetched, name)
+	m.mu.Unlock()
+
+	files, ok := m.Templates[name]
+	if !ok {
+		return fmt.Errorf("template %s not found", name)
+	}
+	fsys := fstest.MapFS{}
+	for p, content := range files {
+		fsys[name+"/"+p] = &fstest.MapFile{Data: []byte(content), Mode: 0644}
+	}
+	return (&FSSource{FS: fsys}).Fetch(ctx, name, dest)
+}
+
+// Fetched lists the templates asked for so far, in order
+func (m *MemorySource) Fetched() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.fetched...)
+}
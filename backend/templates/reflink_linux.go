@@ -0,0 +1,12 @@
+package templates
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink clones src into dest with FICLONE (btrfs, xfs, ...)
+func reflink(dest *os.File, src *os.File) error {
+	return unix.IoctlFileClone(int(dest.Fd()), int(src.Fd()))
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package templates
+
+import (
+	"errors"
+	"os"
+)
+
+func reflink(dest *os.File, src *os.File) error {
+	return errors.New("reflink not supported")
+}
@@ -0,0 +1,80 @@
+package templates
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Source copies a starter template (the catalogue's template name) into a workspace directory
+type Source interface {
+	Fetch(ctx context.Context, template string, dest string) error
+}
+
+//go:embed all:_embedded
+var embedded embed.FS
+
+// FSSource serves templates from <name>/ folders of any fs.FS
+type FSSource struct {
+	FS fs.FS
+}
+
+// NewLocalSource reads templates from dir/<name>/, for offline development
+func NewLocalSource(dir string) *FSSource {
+	return &FSSource{FS: os.DirFS(dir)}
+}
+
+// NewEmbeddedSource serves the starter templates compiled into the binary
+func NewEmbeddedSource() *FSSource {
+	sub, err := fs.Sub(embedded, "_embedded")
+	if err != nil {
+		panic(err)
+	}
+	return &FSSource{FS: sub}
+}
+
+func (s *FSSource) Fetch(ctx context.Context, name string, dest string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return fmt.Errorf("invalid template name: %s", name)
+	}
+
+	return fs.WalkDir(s.FS, name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to read template %s: %v", name, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel := p[len(name):]
+		localPath := filepath.Join(dest, filepath.FromSlash(path.Clean("/"+rel)))
+		if d.IsDir() {
+			return os.MkdirAll(localPath, 0755)
+		}
+		return copyFile(s.FS, p, localPath)
+	})
+}
+
+func copyFile(fsys fs.FS, src string, dest string) error {
+	in, err := fsys.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open template file %s: %v", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %v", dest, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy template file %s: %v", src, err)
+	}
+	return out.Close()
+}
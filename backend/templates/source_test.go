@@ -0,0 +1,106 @@
+package templates
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mudit06mah/CloudIde/catalog"
+)
+
+// readTree returns every regular file under dir by slash separated path
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestFSSourceFetch(t *testing.T) {
+	source := &FSSource{FS: fstest.MapFS{
+		"python/main.py":          {Data: []byte("print('hi')\n")},
+		"python/lib/util.py":      {Data: []byte("x = 1\n")},
+		"python/.vscode/settings": {Data: []byte("{}")},
+		"golang/main.go":          {Data: []byte("package main\n")},
+	}}
+	dest := t.TempDir()
+	if err := source.Fetch(context.Background(), "python", dest); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"main.py": "print('hi')\n", "lib/util.py": "x = 1\n", ".vscode/settings": "{}"}
+	if got := readTree(t, dest); !reflect.DeepEqual(got, want) {
+		t.Errorf("fetched %v, want %v", got, want)
+	}
+}
+
+func TestFSSourceFetchErrors(t *testing.T) {
+	source := &FSSource{FS: fstest.MapFS{"python/main.py": {Data: []byte("")}}}
+	for _, name := range []string{"", ".", "..", "../python", "/python", "missing"} {
+		if err := source.Fetch(context.Background(), name, t.TempDir()); err == nil {
+			t.Errorf("Fetch(%q) succeeded", name)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := source.Fetch(ctx, "python", t.TempDir()); err != context.Canceled {
+		t.Errorf("Fetch with a cancelled ctx = %v, want context.Canceled", err)
+	}
+}
+
+// TestEmbeddedSourceCatalog checks every template the built-in catalogue names is compiled in
+func TestEmbeddedSourceCatalog(t *testing.T) {
+	projectCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewEmbeddedSource()
+	for _, projectType := range projectCatalog.List() {
+		dest := t.TempDir()
+		if err := source.Fetch(context.Background(), projectType.Template, dest); err != nil {
+			t.Errorf("%s: %v", projectType.Name, err)
+			continue
+		}
+		if len(readTree(t, dest)) == 0 {
+			t.Errorf("%s: template %s has no files", projectType.Name, projectType.Template)
+		}
+	}
+}
+
+func TestMemorySource(t *testing.T) {
+	source := &MemorySource{Templates: map[string]map[string]string{
+		"node": {"index.js": "console.log(1)\n", "src/app.js": ""},
+	}}
+	dest := t.TempDir()
+	if err := source.Fetch(context.Background(), "node", dest); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"index.js": "console.log(1)\n", "src/app.js": ""}
+	if got := readTree(t, dest); !reflect.DeepEqual(got, want) {
+		t.Errorf("fetched %v, want %v", got, want)
+	}
+	if err := source.Fetch(context.Background(), "missing", t.TempDir()); err == nil {
+		t.Error("fetching an unknown template succeeded")
+	}
+	if got := source.Fetched(); !reflect.DeepEqual(got, []string{"node", "missing"}) {
+		t.Errorf("Fetched() = %v", got)
+	}
+}
@@ -0,0 +1,40 @@
+package workspace
+
+import (
+	"sync"
+	"time"
+)
+
+// Activity tracks the last time each workspace was used (file ops, terminal input).
+// It is in memory only; after a restart the reaper falls back to the record's UpdatedAt.
+type Activity struct {
+	mu   sync.RWMutex
+	last map[string]time.Time
+	now  func() time.Time
+}
+
+func NewActivity() *Activity {
+	return &Activity{last: make(map[string]time.Time), now: time.Now}
+}
+
+func (a *Activity) Touch(id string) {
+	if id == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.last[id] = a.now()
+}
+
+func (a *Activity) LastSeen(id string) (time.Time, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	t, ok := a.last[id]
+	return t, ok
+}
+
+func (a *Activity) Forget(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.last, id)
+}
@@ -0,0 +1,150 @@
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var workspacesBucket = []byte("workspaces")
+
+// BoltStore persists records as JSON in a single BoltDB file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %v", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workspace store %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(workspacesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create workspaces bucket: %v", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (b *BoltStore) Get(id string) (*Record, error) {
+	var rec Record
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(workspacesBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (b *BoltStore) Put(rec *Record) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(workspacesBucket)
+		var revision uint64
+		if stored, err := decodeRecord(bucket, rec.ID); err == nil {
+			revision = stored.Revision
+		} else if err != ErrNotFound {
+			return err
+		}
+		rec.Revision = revision + 1
+		return putRecord(bucket, rec)
+	})
+}
+
+func (b *BoltStore) Update(id string, fn func(rec *Record) error) (*Record, error) {
+	var rec *Record
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(workspacesBucket)
+		stored, err := decodeRecord(bucket, id)
+		if err != nil {
+			return err
+		}
+		revision := stored.Revision
+		if err := fn(stored); err != nil {
+			return err
+		}
+		stored.ID, stored.Revision = id, revision+1
+		rec = stored
+		return putRecord(bucket, stored)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (b *BoltStore) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(workspacesBucket).Delete([]byte(id))
+	})
+}
+
+func (b *BoltStore) CompareAndDelete(id string, revision uint64) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(workspacesBucket)
+		stored, err := decodeRecord(bucket, id)
+		if err != nil {
+			return err
+		}
+		if stored.Revision != revision {
+			return ErrConflict
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+func decodeRecord(bucket *bolt.Bucket, id string) (*Record, error) {
+	data := bucket.Get([]byte(id))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("failed to decode workspace %s: %v", id, err)
+	}
+	return &rec, nil
+}
+
+func putRecord(bucket *bolt.Bucket, rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal workspace %s: %v", rec.ID, err)
+	}
+	return bucket.Put([]byte(rec.ID), data)
+}
+
+func (b *BoltStore) List() ([]*Record, error) {
+	var list []*Record
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(workspacesBucket).ForEach(func(k, v []byte) error {
+			var rec Record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("failed to decode workspace %s: %v", k, err)
+			}
+			list = append(list, &rec)
+			return nil
+		})
+	})
+	return list, err
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
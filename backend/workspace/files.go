@@ -0,0 +1,29 @@
+package workspace
+
+import "context"
+
+// Entry is one item of a directory listing
+type Entry struct {
+	Name  string `json:"name"`
+	IsDir bool   `json:"isDir"`
+}
+
+// FileService gives access to a workspace's files wherever they are stored.
+// Paths are client paths, relative to the workspace root ("/" being the root itself).
+// Every call gives up once ctx, the request it serves, is done.
+type FileService interface {
+	Create(ctx context.Context, clientPath string) error
+	ReadFile(ctx context.Context, clientPath string) ([]byte, error)
+	WriteFile(ctx context.Context, clientPath string, data []byte) error
+	Remove(ctx context.Context, clientPath string) error
+	MkdirAll(ctx context.Context, clientPath string) error
+	RemoveAll(ctx context.Context, clientPath string) error
+	ReadDir(ctx context.Context, clientPath string) ([]Entry, error)
+	Rename(ctx context.Context, from string, to string) error
+}
+
+// Watcher is implemented by file services that can report changes below a folder.
+// The channel is closed once ctx is done.
+type Watcher interface {
+	Watch(ctx context.Context, clientPath string) (<-chan Event, error)
+}
@@ -0,0 +1,280 @@
+package workspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrOutsideWorkspace is matched (via errors.Is) by every PathError
+var ErrOutsideWorkspace = errors.New("path outside workspace")
+
+// PathError is returned when a client path resolves outside the workspace root
+type PathError struct {
+	Path string
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrOutsideWorkspace, e.Path)
+}
+
+func (e *PathError) Is(target error) bool {
+	return target == ErrOutsideWorkspace
+}
+
+// FS resolves client supplied paths against a single workspace directory.
+// Client paths are always treated as relative to the root, "/" being the root itself.
+// Files are opened through os.Root, so a symlink swapped in after Resolve still can't
+// lead outside the root. Local calls are short, so ctx is only checked before each starts.
+type FS struct {
+	Root string
+}
+
+func NewFS(workspaceId string) *FS {
+	return &FS{Root: filepath.Join(os.Getenv("CACHE_DIR"), workspaceId)}
+}
+
+// Resolve maps a client path to an absolute path on disk, rejecting
+// ".." escapes, symlinks that point outside the root and dangling symlinks.
+func (f *FS) Resolve(clientPath string) (string, error) {
+	root, err := filepath.Abs(f.Root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve workspace root: %v", err)
+	}
+
+	full := filepath.Join(root, filepath.FromSlash(clientPath))
+	if !within(root, full) {
+		return "", &PathError{Path: clientPath}
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve workspace root: %v", err)
+	}
+
+	//walk up to the deepest existing ancestor and make sure it stays inside the root:
+	existing := full
+	for {
+		real, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			if !within(realRoot, real) {
+				return "", &PathError{Path: clientPath}
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		//a dangling symlink would be followed by the write creating its target:
+		if info, err := os.Lstat(existing); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", &PathError{Path: clientPath}
+		}
+		if existing == root {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+
+	return full, nil
+}
+
+// Rel converts an absolute path inside the root back to the client form ("/a/b")
+func (f *FS) Rel(fullPath string) string {
+	root, err := filepath.Abs(f.Root)
+	if err != nil {
+		root = f.Root
+	}
+	rel, err := filepath.Rel(root, fullPath)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// open resolves a client path and opens the workspace root for it; name is the path
+// relative to the root, "." being the root itself
+func (f *FS) open(ctx context.Context, clientPath string) (root *os.Root, name string, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	full, err := f.Resolve(clientPath)
+	if err != nil {
+		return nil, "", err
+	}
+	abs, err := filepath.Abs(f.Root)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to resolve workspace root: %v", err)
+	}
+	name, err = filepath.Rel(abs, full)
+	if err != nil {
+		return nil, "", &PathError{Path: clientPath}
+	}
+	root, err = os.OpenRoot(abs)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open workspace root: %v", err)
+	}
+	return root, name, nil
+}
+
+func (f *FS) Create(ctx context.Context, clientPath string) error {
+	root, name, err := f.open(ctx, clientPath)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	file, err := root.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (f *FS) ReadFile(ctx context.Context, clientPath string) ([]byte, error) {
+	root, name, err := f.open(ctx, clientPath)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+func (f *FS) WriteFile(ctx context.Context, clientPath string, data []byte) error {
+	root, name, err := f.open(ctx, clientPath)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	file, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *FS) Remove(ctx context.Context, clientPath string) error {
+	root, name, err := f.open(ctx, clientPath)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	if name == "." {
+		return &PathError{Path: clientPath}
+	}
+	return root.Remove(name)
+}
+
+func (f *FS) MkdirAll(ctx context.Context, clientPath string) error {
+	root, name, err := f.open(ctx, clientPath)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	if name == "." {
+		return nil
+	}
+
+	//os.Root has no MkdirAll yet, so create one level at a time:
+	dir := ""
+	for _, elem := range strings.Split(name, string(filepath.Separator)) {
+		dir = filepath.Join(dir, elem)
+		if err := root.Mkdir(dir, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+	info, err := root.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", clientPath)
+	}
+	return nil
+}
+
+// RemoveAll deletes a folder inside the workspace; the root itself can't be removed this way.
+// os.RemoveAll and os.Rename don't follow a trailing symlink, and Resolve checked the parents.
+func (f *FS) RemoveAll(ctx context.Context, clientPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	full, err := f.Resolve(clientPath)
+	if err != nil {
+		return err
+	}
+	if f.isRoot(full) {
+		return &PathError{Path: clientPath}
+	}
+	return os.RemoveAll(full)
+}
+
+func (f *FS) ReadDir(ctx context.Context, clientPath string) ([]Entry, error) {
+	root, name, err := f.open(ctx, clientPath)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	dir, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	dirEntries, err := dir.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(dirEntries, func(i, j int) bool { return dirEntries[i].Name() < dirEntries[j].Name() })
+
+	entries := make([]Entry, 0, len(dirEntries))
+	for _, entry := range dirEntries {
+		entries = append(entries, Entry{Name: entry.Name(), IsDir: entry.IsDir()})
+	}
+	return entries, nil
+}
+
+func (f *FS) Rename(ctx context.Context, from string, to string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fullFrom, err := f.Resolve(from)
+	if err != nil {
+		return err
+	}
+	fullTo, err := f.Resolve(to)
+	if err != nil {
+		return err
+	}
+	if f.isRoot(fullFrom) || f.isRoot(fullTo) {
+		return &PathError{Path: from}
+	}
+	return os.Rename(fullFrom, fullTo)
+}
+
+func (f *FS) isRoot(full string) bool {
+	root, err := filepath.Abs(f.Root)
+	if err != nil {
+		return false
+	}
+	return full == root
+}
+
+func within(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,113 @@
+package workspace
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestFS creates a workspace root next to an "outside" folder that must never be touched
+func newTestFS(t *testing.T) (*FS, string) {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "src"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "src", "main.go"), []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../outside", filepath.Join(root, "dirlink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../outside/pwned", filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "abslink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("src", filepath.Join(root, "inlink")); err != nil {
+		t.Fatal(err)
+	}
+	return &FS{Root: root}, outside
+}
+
+func TestFSTraversal(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		op      func(f *FS) error
+		escapes bool
+	}{
+		{"read inside", func(f *FS) error { _, err := f.ReadFile(ctx, "/src/main.go"); return err }, false},
+		{"write inside", func(f *FS) error { return f.WriteFile(ctx, "/src/new.go", nil) }, false},
+		{"symlink inside the root", func(f *FS) error { _, err := f.ReadFile(ctx, "/inlink/main.go"); return err }, false},
+		{"absolute path is rooted", func(f *FS) error { return f.MkdirAll(ctx, "/etc/conf") }, false},
+
+		{"dotdot read", func(f *FS) error { _, err := f.ReadFile(ctx, "../outside/secret"); return err }, true},
+		{"dotdot after root", func(f *FS) error { _, err := f.ReadFile(ctx, "/../outside/secret"); return err }, true},
+		{"dotdot inside path", func(f *FS) error { return f.WriteFile(ctx, "/src/../../outside/secret", nil) }, true},
+		{"dotdot mkdir", func(f *FS) error { return f.MkdirAll(ctx, "/../outside/dir") }, true},
+		{"dotdot rename target", func(f *FS) error { return f.Rename(ctx, "/src/main.go", "/../outside/main.go") }, true},
+		{"symlinked dir read", func(f *FS) error { _, err := f.ReadFile(ctx, "/dirlink/secret"); return err }, true},
+		{"symlinked dir write", func(f *FS) error { return f.WriteFile(ctx, "/dirlink/secret", nil) }, true},
+		{"symlinked dir list", func(f *FS) error { _, err := f.ReadDir(ctx, "/dirlink"); return err }, true},
+		{"absolute symlink", func(f *FS) error { return f.Create(ctx, "/abslink/new") }, true},
+		{"dangling symlink write", func(f *FS) error { return f.WriteFile(ctx, "/dangling", []byte("x")) }, true},
+		{"dangling symlink create", func(f *FS) error { return f.Create(ctx, "/dangling") }, true},
+		{"dangling symlink mkdir", func(f *FS) error { return f.MkdirAll(ctx, "/dangling/dir") }, true},
+		{"remove root", func(f *FS) error { return f.Remove(ctx, "/") }, true},
+		{"remove all root", func(f *FS) error { return f.RemoveAll(ctx, "/") }, true},
+		{"remove all root via dotdot", func(f *FS) error { return f.RemoveAll(ctx, "/src/..") }, true},
+		{"remove all outside", func(f *FS) error { return f.RemoveAll(ctx, "/..") }, true},
+		{"rename root", func(f *FS) error { return f.Rename(ctx, "/", "/moved") }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, outside := newTestFS(t)
+			err := tt.op(f)
+			if !tt.escapes {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			//nothing outside the root may have changed:
+			entries, err := os.ReadDir(outside)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].Name() != "secret" {
+				t.Errorf("outside folder changed: %v", entries)
+			}
+			if data, _ := os.ReadFile(filepath.Join(outside, "secret")); string(data) != "secret" {
+				t.Errorf("outside file changed: %q", data)
+			}
+			if _, err := os.Stat(f.Root); err != nil {
+				t.Errorf("root removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestFSResolveErrors(t *testing.T) {
+	f, _ := newTestFS(t)
+	for _, p := range []string{"../x", "/dirlink/secret", "/dangling"} {
+		if _, err := f.Resolve(p); !errors.Is(err, ErrOutsideWorkspace) {
+			t.Errorf("Resolve(%q) = %v, want ErrOutsideWorkspace", p, err)
+		}
+	}
+}
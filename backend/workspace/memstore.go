@@ -0,0 +1,92 @@
+package workspace
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps records in memory only; used for tests and throwaway setups
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (m *MemoryStore) Get(id string) (*Record, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rec, ok := m.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return rec.clone(), nil
+}
+
+func (m *MemoryStore) Put(rec *Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec.Revision = m.records[rec.ID].Revision + 1
+	m.records[rec.ID] = *rec.clone()
+	return nil
+}
+
+func (m *MemoryStore) Update(id string, fn func(rec *Record) error) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	rec := stored.clone()
+	if err := fn(rec); err != nil {
+		return nil, err
+	}
+	rec.ID, rec.Revision = id, stored.Revision+1
+	m.records[id] = *rec.clone()
+	return rec, nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, id)
+	return nil
+}
+
+func (m *MemoryStore) CompareAndDelete(id string, revision uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.records[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if stored.Revision != revision {
+		return ErrConflict
+	}
+	delete(m.records, id)
+	return nil
+}
+
+func (m *MemoryStore) List() ([]*Record, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := make([]*Record, 0, len(m.records))
+	for _, rec := range m.records {
+		list = append(list, rec.clone())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// clone copies the record so callers can't mutate stored state
+func (r Record) clone() *Record {
+	r.Resources = append([]Resource(nil), r.Resources...)
+	return &r
+}
@@ -0,0 +1,309 @@
+package workspace
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Collector deletes every Kubernetes object belonging to a workspace
+type Collector interface {
+	DeleteWorkspace(ctx context.Context, workspaceId string) error
+}
+
+// Stopper snapshots a workspace's files and deletes its resources, keeping the record
+// as stopped so it can be reopened
+type Stopper interface {
+	StopWorkspace(ctx context.Context, workspaceId string) error
+}
+
+// Reaper suspends workspaces idle for IdleTTL (the pod is deleted, files are kept)
+// and fully deletes workspaces idle for HardTTL. A zero TTL disables that step.
+// Workspaces that never ran and failed, or are still provisioning after ProvisionTTL, are
+// deleted too. Deleting removes the resources (everything labelled with the workspace,
+// given a Collector), the files and the record. With a Stopper, workspaces past HardTTL go
+// through it instead so their files are snapshotted first; those that never ran are always
+// deleted outright. A workspace that ran and then failed to reopen waits for HardTTL.
+// Records written between the sweep's listing and the reaper acting on them are skipped.
+type Reaper struct {
+	Store     Store
+	Activity  *Activity
+	Kube      kubernetes.Interface
+	Collector Collector
+	Stopper   Stopper
+	Namespace string
+	CacheDir  string
+	IdleTTL   time.Duration
+	HardTTL   time.Duration
+	// ProvisionTTL is the grace period of a provisioning workspace; a request that died
+	// (e.g. with the backend) leaves the record in that state
+	ProvisionTTL time.Duration
+	Interval     time.Duration
+
+	now func() time.Time
+}
+
+// NewReaperFromEnv reads REAPER_IDLE_TTL, REAPER_HARD_TTL, REAPER_PROVISION_TTL and
+// REAPER_INTERVAL (Go durations, defaulting to 30m, 24h, 15m and 1m)
+func NewReaperFromEnv(store Store, activity *Activity, kube kubernetes.Interface, namespace string) (*Reaper, error) {
+	idle, err := durationEnv("REAPER_IDLE_TTL", 30*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	hard, err := durationEnv("REAPER_HARD_TTL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	provision, err := durationEnv("REAPER_PROVISION_TTL", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	interval, err := durationEnv("REAPER_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("REAPER_INTERVAL must be positive")
+	}
+
+	return &Reaper{
+		Store:        store,
+		Activity:     activity,
+		Kube:         kube,
+		Namespace:    namespace,
+		CacheDir:     os.Getenv("CACHE_DIR"),
+		IdleTTL:      idle,
+		HardTTL:      hard,
+		ProvisionTTL: provision,
+		Interval:     interval,
+	}, nil
+}
+
+func durationEnv(key string, def time.Duration) (time.Duration, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return d, nil
+}
+
+// Run sweeps every Interval until ctx is cancelled
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Sweep(ctx); err != nil {
+				log.Println("Reaper sweep failed:", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sweep makes a single pass over every known workspace
+func (r *Reaper) Sweep(ctx context.Context) error {
+	records, err := r.Store.List()
+	if err != nil {
+		return fmt.Errorf("failed to list workspaces: %v", err)
+	}
+
+	now := r.clock()
+	for _, rec := range records {
+		//stopped workspaces hold no cluster or local resources, only their snapshot:
+		if rec.State == StateStopped {
+			continue
+		}
+
+		idle := now.Sub(r.lastActive(rec))
+		stuck := rec.State == StateProvisioning && r.ProvisionTTL > 0 && now.Sub(rec.UpdatedAt) >= r.ProvisionTTL
+		switch {
+		case rec.NeverRan() && (rec.State == StateFailed || stuck):
+			if err := r.remove(ctx, rec); err != nil {
+				log.Printf("Reaper failed to delete workspace %s: %v\n", rec.ID, err)
+			}
+		case rec.State == StateProvisioning && !stuck:
+		case r.HardTTL > 0 && idle >= r.HardTTL:
+			if err := r.expire(ctx, rec); err != nil {
+				log.Printf("Reaper failed to delete workspace %s: %v\n", rec.ID, err)
+			}
+		case r.IdleTTL > 0 && idle >= r.IdleTTL && rec.State == StateRunning:
+			if err := r.suspend(ctx, rec); err != nil {
+				log.Printf("Reaper failed to suspend workspace %s: %v\n", rec.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Reaper) lastActive(rec *Record) time.Time {
+	last := rec.UpdatedAt
+	if r.Activity != nil {
+		if seen, ok := r.Activity.LastSeen(rec.ID); ok && seen.After(last) {
+			last = seen
+		}
+	}
+	return last
+}
+
+// claim marks the record with what the reaper is about to do, provided nothing wrote it since
+// the sweep listed it and it is still eligible (ErrConflict otherwise). Claiming before touching
+// the cluster keeps the reaper from undoing a reopen that raced with the sweep.
+func (r *Reaper) claim(rec *Record, eligible func(cur *Record) bool, mark func(cur *Record)) (*Record, error) {
+	claimed, err := r.Store.Update(rec.ID, func(cur *Record) error {
+		if cur.Revision != rec.Revision || !eligible(cur) {
+			return ErrConflict
+		}
+		if mark != nil {
+			mark(cur)
+		}
+		return nil
+	})
+	if err == ErrConflict {
+		log.Printf("Reaper skipped workspace %s, it changed during the sweep\n", rec.ID)
+	}
+	return claimed, err
+}
+
+// suspend deletes the workspace pods (StatefulSets are scaled to zero, keeping their volume)
+// but keeps its files, service and ingress
+func (r *Reaper) suspend(ctx context.Context, rec *Record) error {
+	rec, err := r.claim(rec, func(cur *Record) bool {
+		return cur.State == StateRunning && r.clock().Sub(r.lastActive(cur)) >= r.IdleTTL
+	}, func(cur *Record) {
+		cur.State = StateSuspended
+		cur.UpdatedAt = r.clock()
+	})
+	if err == ErrConflict {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, res := range rec.Resources {
+		switch res.Kind {
+		case "Pod":
+			if err := r.deleteResource(ctx, res); err != nil {
+				return err
+			}
+		case "StatefulSet":
+			scale := []byte(`{"spec":{"replicas":0}}`)
+			_, err := r.Kube.AppsV1().StatefulSets(r.Namespace).Patch(ctx, res.Name, types.MergePatchType, scale, metav1.PatchOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("error scaling down %s %s: %v", res.Kind, res.Name, err)
+			}
+		}
+	}
+
+	log.Printf("Reaper suspended idle workspace %s\n", rec.ID)
+	return nil
+}
+
+// expire stops an expired workspace through the Stopper, or removes it without one
+func (r *Reaper) expire(ctx context.Context, rec *Record) error {
+	if r.Stopper == nil {
+		return r.remove(ctx, rec)
+	}
+	_, err := r.claim(rec, func(cur *Record) bool {
+		return cur.State == rec.State && r.clock().Sub(r.lastActive(cur)) >= r.HardTTL
+	}, nil)
+	if err == ErrConflict {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := r.Stopper.StopWorkspace(ctx, rec.ID); err != nil {
+		return err
+	}
+	log.Printf("Reaper stopped expired workspace %s\n", rec.ID)
+	return nil
+}
+
+// remove deletes the workspace's resources, the cache directory and the record itself,
+// without snapshotting anything. The record is kept if it is written meanwhile.
+func (r *Reaper) remove(ctx context.Context, rec *Record) error {
+	rec, err := r.claim(rec, func(cur *Record) bool { return cur.State == rec.State }, nil)
+	if err == ErrConflict {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if r.Collector != nil {
+		deleteCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		err := r.Collector.DeleteWorkspace(deleteCtx, rec.ID)
+		cancel()
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, res := range rec.Resources {
+			if err := r.deleteResource(ctx, res); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.RemoveAll(filepath.Join(r.CacheDir, rec.ID)); err != nil {
+		return fmt.Errorf("failed to delete cache: %v", err)
+	}
+
+	if r.Activity != nil {
+		r.Activity.Forget(rec.ID)
+	}
+	if err := r.Store.CompareAndDelete(rec.ID, rec.Revision); err == ErrConflict {
+		log.Printf("Reaper kept workspace %s, it changed while being deleted\n", rec.ID)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	log.Printf("Reaper deleted workspace %s (%s)\n", rec.ID, rec.State)
+	return nil
+}
+
+func (r *Reaper) deleteResource(ctx context.Context, res Resource) error {
+	var err error
+	opts := metav1.DeleteOptions{}
+
+	switch res.Kind {
+	case "Pod":
+		err = r.Kube.CoreV1().Pods(r.Namespace).Delete(ctx, res.Name, opts)
+	case "StatefulSet":
+		err = r.Kube.AppsV1().StatefulSets(r.Namespace).Delete(ctx, res.Name, opts)
+	case "Service":
+		err = r.Kube.CoreV1().Services(r.Namespace).Delete(ctx, res.Name, opts)
+	case "Ingress":
+		err = r.Kube.NetworkingV1().Ingresses(r.Namespace).Delete(ctx, res.Name, opts)
+	case "NetworkPolicy":
+		err = r.Kube.NetworkingV1().NetworkPolicies(r.Namespace).Delete(ctx, res.Name, opts)
+	default:
+		return fmt.Errorf("reaper can't delete kind %s", res.Kind)
+	}
+
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error deleting %s %s: %v", res.Kind, res.Name, err)
+	}
+	return nil
+}
+
+func (r *Reaper) clock() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,304 @@
+package workspace
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testNamespace = "cloud-ide"
+
+var testNow = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestReaper returns a reaper at testNow over a fake cluster holding a pod, a
+// StatefulSet and a service for every record, each record with a cache directory
+func newTestReaper(t *testing.T, records ...*Record) (*Reaper, *fake.Clientset) {
+	t.Helper()
+	kube := fake.NewSimpleClientset()
+	store := NewMemoryStore()
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+
+	for _, rec := range records {
+		meta := metav1.ObjectMeta{Name: "shell-" + rec.ID, Namespace: testNamespace}
+		kube.CoreV1().Pods(testNamespace).Create(ctx, &corev1.Pod{ObjectMeta: meta}, metav1.CreateOptions{})
+		kube.AppsV1().StatefulSets(testNamespace).Create(ctx, &appsv1.StatefulSet{ObjectMeta: meta}, metav1.CreateOptions{})
+		kube.CoreV1().Services(testNamespace).Create(ctx, &corev1.Service{ObjectMeta: meta}, metav1.CreateOptions{})
+		rec.Resources = []Resource{{Kind: "Pod", Name: meta.Name}, {Kind: "StatefulSet", Name: meta.Name}, {Kind: "Service", Name: meta.Name}}
+		if err := store.Put(rec); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(cacheDir, rec.ID), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &Reaper{
+		Store:        store,
+		Activity:     NewActivity(),
+		Kube:         kube,
+		Namespace:    testNamespace,
+		CacheDir:     cacheDir,
+		IdleTTL:      30 * time.Minute,
+		HardTTL:      24 * time.Hour,
+		ProvisionTTL: 15 * time.Minute,
+		Interval:     time.Minute,
+		now:          func() time.Time { return testNow },
+	}, kube
+}
+
+func reaperRecord(id string, state State, age time.Duration) *Record {
+	return &Record{ID: id, Owner: "user-1", State: state, CreatedAt: testNow.Add(-age), UpdatedAt: testNow.Add(-age)}
+}
+
+func podExists(t *testing.T, kube *fake.Clientset, id string) bool {
+	t.Helper()
+	_, err := kube.CoreV1().Pods(testNamespace).Get(context.Background(), "shell-"+id, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestReaperSweep(t *testing.T) {
+	tests := []struct {
+		name  string
+		state State
+		age   time.Duration
+		// touched is how long ago the workspace was last used, if at all
+		touched time.Duration
+		started bool
+
+		wantState   State
+		wantRemoved bool
+		wantPod     bool
+	}{
+		{name: "active", state: StateRunning, age: time.Minute, wantState: StateRunning, wantPod: true},
+		{name: "idle", state: StateRunning, age: time.Hour, wantState: StateSuspended},
+		{name: "recent activity", state: StateRunning, age: time.Hour, touched: time.Minute, wantState: StateRunning, wantPod: true},
+		{name: "suspended stays", state: StateSuspended, age: time.Hour, wantState: StateSuspended, wantPod: true},
+		{name: "expired", state: StateSuspended, age: 25 * time.Hour, wantRemoved: true},
+		{name: "stopped", state: StateStopped, age: 48 * time.Hour, wantState: StateStopped, wantPod: true},
+		{name: "provisioning", state: StateProvisioning, age: 5 * time.Minute, wantState: StateProvisioning, wantPod: true},
+		{name: "stuck provisioning", state: StateProvisioning, age: 20 * time.Minute, wantRemoved: true},
+		{name: "failed", state: StateFailed, age: time.Second, wantRemoved: true},
+		{name: "failed reopen", state: StateFailed, started: true, age: time.Hour, wantState: StateFailed, wantPod: true},
+		{name: "failed reopen expired", state: StateFailed, started: true, age: 25 * time.Hour, wantRemoved: true},
+		{name: "stuck reopen", state: StateProvisioning, started: true, age: 20 * time.Minute, wantState: StateProvisioning, wantPod: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := reaperRecord("ws1", tt.state, tt.age)
+			rec.Started = tt.started
+			reaper, kube := newTestReaper(t, rec)
+			if tt.touched > 0 {
+				reaper.Activity.now = func() time.Time { return testNow.Add(-tt.touched) }
+				reaper.Activity.Touch("ws1")
+			}
+
+			if err := reaper.Sweep(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			rec, err := reaper.Store.Get("ws1")
+			if tt.wantRemoved {
+				if err != ErrNotFound {
+					t.Errorf("record still present (%v)", err)
+				}
+				if _, err := os.Stat(filepath.Join(reaper.CacheDir, "ws1")); !os.IsNotExist(err) {
+					t.Errorf("cache dir still present (%v)", err)
+				}
+				if podExists(t, kube, "ws1") {
+					t.Error("pod not deleted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.State != tt.wantState {
+				t.Errorf("state %s, want %s", rec.State, tt.wantState)
+			}
+			if podExists(t, kube, "ws1") != tt.wantPod {
+				t.Errorf("pod exists %v, want %v", !tt.wantPod, tt.wantPod)
+			}
+		})
+	}
+}
+
+func TestReaperSuspendScalesDown(t *testing.T) {
+	reaper, kube := newTestReaper(t, reaperRecord("ws1", StateRunning, time.Hour))
+	if err := reaper.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	sts, err := kube.AppsV1().StatefulSets(testNamespace).Get(context.Background(), "shell-ws1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sts.Spec.Replicas == nil || *sts.Spec.Replicas != 0 {
+		t.Errorf("replicas %v, want 0", sts.Spec.Replicas)
+	}
+	if _, err := kube.CoreV1().Services(testNamespace).Get(context.Background(), "shell-ws1", metav1.GetOptions{}); err != nil {
+		t.Errorf("service deleted on suspend: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(reaper.CacheDir, "ws1")); err != nil {
+		t.Errorf("files deleted on suspend: %v", err)
+	}
+}
+
+func TestReaperDisabledTTLs(t *testing.T) {
+	reaper, kube := newTestReaper(t, reaperRecord("ws1", StateRunning, 48*time.Hour), reaperRecord("ws2", StateProvisioning, 48*time.Hour))
+	reaper.IdleTTL, reaper.HardTTL, reaper.ProvisionTTL = 0, 0, 0
+
+	if err := reaper.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"ws1", "ws2"} {
+		if _, err := reaper.Store.Get(id); err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+		if !podExists(t, kube, id) {
+			t.Errorf("%s: pod deleted with reaping disabled", id)
+		}
+	}
+}
+
+// recordingStopper stops workspaces the way the websocket server does, without a cluster
+type recordingStopper struct {
+	store   Store
+	stopped []string
+}
+
+func (s *recordingStopper) StopWorkspace(ctx context.Context, workspaceId string) error {
+	s.stopped = append(s.stopped, workspaceId)
+	rec, err := s.store.Get(workspaceId)
+	if err != nil {
+		return err
+	}
+	rec.State = StateStopped
+	return s.store.Put(rec)
+}
+
+func TestReaperRemoveUsesStopper(t *testing.T) {
+	reaper, kube := newTestReaper(t, reaperRecord("expired", StateRunning, 25*time.Hour), reaperRecord("idle", StateRunning, time.Hour))
+	stopper := &recordingStopper{store: reaper.Store}
+	reaper.Stopper = stopper
+
+	if err := reaper.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(stopper.stopped) != 1 || stopper.stopped[0] != "expired" {
+		t.Fatalf("stopped %v, want [expired]", stopper.stopped)
+	}
+	rec, err := reaper.Store.Get("expired")
+	if err != nil || rec.State != StateStopped {
+		t.Errorf("expired workspace record %+v, %v; want it kept as stopped", rec, err)
+	}
+	//the stopper owns the deletion, the reaper mustn't delete anything itself:
+	if !podExists(t, kube, "expired") {
+		t.Error("reaper deleted the pod itself")
+	}
+	if _, err := os.Stat(filepath.Join(reaper.CacheDir, "expired")); err != nil {
+		t.Errorf("reaper deleted the files itself: %v", err)
+	}
+}
+
+// recordingCollector deletes workspace objects the way the k8s client does, by label
+type recordingCollector struct {
+	collected []string
+}
+
+func (c *recordingCollector) DeleteWorkspace(ctx context.Context, workspaceId string) error {
+	c.collected = append(c.collected, workspaceId)
+	return nil
+}
+
+// TestReaperDeletesFailedWithStopper checks that failed and stuck workspaces are deleted
+// outright, as the reaper runs in production, rather than stopped and kept
+func TestReaperDeletesFailedWithStopper(t *testing.T) {
+	reaper, _ := newTestReaper(t,
+		reaperRecord("failed", StateFailed, time.Second),
+		reaperRecord("stuck", StateProvisioning, 20*time.Minute),
+		reaperRecord("expired", StateRunning, 25*time.Hour))
+	stopper := &recordingStopper{store: reaper.Store}
+	collector := &recordingCollector{}
+	reaper.Stopper, reaper.Collector = stopper, collector
+
+	if err := reaper.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(stopper.stopped) != 1 || stopper.stopped[0] != "expired" {
+		t.Errorf("stopped %v, want [expired]", stopper.stopped)
+	}
+	if len(collector.collected) != 2 {
+		t.Errorf("collected %v, want failed and stuck", collector.collected)
+	}
+	for _, id := range []string{"failed", "stuck"} {
+		if _, err := reaper.Store.Get(id); err != ErrNotFound {
+			t.Errorf("%s: record still present (%v)", id, err)
+		}
+		if _, err := os.Stat(filepath.Join(reaper.CacheDir, id)); !os.IsNotExist(err) {
+			t.Errorf("%s: cache dir still present (%v)", id, err)
+		}
+	}
+}
+
+// reopeningStore reopens every workspace right after the sweep lists it, as a user opening it
+// between the reaper's read and its write would
+type reopeningStore struct {
+	Store
+}
+
+func (s *reopeningStore) List() ([]*Record, error) {
+	list, err := s.Store.List()
+	for _, rec := range list {
+		reopened := *rec
+		reopened.State, reopened.Started = StateRunning, true
+		if err := s.Store.Put(&reopened); err != nil {
+			return nil, err
+		}
+	}
+	return list, err
+}
+
+func TestReaperSkipsReopened(t *testing.T) {
+	reaper, kube := newTestReaper(t,
+		reaperRecord("idle", StateRunning, time.Hour),
+		reaperRecord("failed", StateFailed, time.Minute),
+		reaperRecord("expired", StateSuspended, 48*time.Hour))
+	reaper.Store = &reopeningStore{reaper.Store}
+	if err := reaper.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"idle", "failed", "expired"} {
+		rec, err := reaper.Store.Get(id)
+		if err != nil {
+			t.Errorf("%s: record deleted after it was reopened: %v", id, err)
+			continue
+		}
+		if rec.State != StateRunning {
+			t.Errorf("%s: state %s after it was reopened, want running", id, rec.State)
+		}
+		if !podExists(t, kube, id) {
+			t.Errorf("%s: pod deleted after it was reopened", id)
+		}
+	}
+	sts, err := kube.AppsV1().StatefulSets(testNamespace).Get(context.Background(), "shell-idle", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sts.Spec.Replicas != nil {
+		t.Errorf("StatefulSet scaled to %d after it was reopened", *sts.Spec.Replicas)
+	}
+}
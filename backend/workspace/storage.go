@@ -0,0 +1,86 @@
+package workspace
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// StorageBackend is where a workspace's files live
+type StorageBackend string
+
+const (
+	// StorageHostPath keeps files in CACHE_DIR on the backend's node, mounted into a bare Pod
+	StorageHostPath StorageBackend = "hostpath"
+	// StorageVolume keeps files on a PersistentVolumeClaim owned by a single replica StatefulSet;
+	// the backend reaches them through the pod
+	StorageVolume StorageBackend = "volume"
+)
+
+// Storage is the deployment wide storage configuration for new workspaces
+type Storage struct {
+	Backend StorageBackend
+	// Size and Class apply to the volume backend's claims; an empty Class uses the cluster default
+	Size  string
+	Class string
+
+	Agent FileAgent
+}
+
+// StorageFromEnv reads WORKSPACE_STORAGE (hostpath by default), WORKSPACE_STORAGE_SIZE (default 5Gi),
+// WORKSPACE_STORAGE_CLASS, FILE_AGENT_IMAGE and FILE_AGENT_SECRET
+func StorageFromEnv() (Storage, error) {
+	storage := Storage{
+		Backend: StorageBackend(os.Getenv("WORKSPACE_STORAGE")),
+		Size:    os.Getenv("WORKSPACE_STORAGE_SIZE"),
+		Class:   os.Getenv("WORKSPACE_STORAGE_CLASS"),
+		Agent: FileAgent{
+			Image:  os.Getenv("FILE_AGENT_IMAGE"),
+			Secret: os.Getenv("FILE_AGENT_SECRET"),
+		},
+	}
+	if storage.Backend == "" {
+		storage.Backend = StorageHostPath
+	}
+	if storage.Size == "" {
+		storage.Size = "5Gi"
+	}
+
+	if !storage.Backend.Valid() {
+		return Storage{}, fmt.Errorf("unsupported WORKSPACE_STORAGE: %s", storage.Backend)
+	}
+	if _, err := resource.ParseQuantity(storage.Size); err != nil {
+		return Storage{}, fmt.Errorf("invalid WORKSPACE_STORAGE_SIZE %s: %v", storage.Size, err)
+	}
+	// the agent listens on the pod network, it must never run unauthenticated
+	if storage.Agent.Image != "" && storage.Agent.Secret == "" {
+		return Storage{}, fmt.Errorf("FILE_AGENT_IMAGE is set without FILE_AGENT_SECRET")
+	}
+	return storage, nil
+}
+
+func (b StorageBackend) Valid() bool {
+	return b == StorageHostPath || b == StorageVolume
+}
+
+// FileAgent configures the file agent sidecar; without an Image workspaces get no agent
+type FileAgent struct {
+	Image string
+	// Secret derives each workspace's agent token, see Token
+	Secret string
+}
+
+// Token is the bearer token the agent of workspaceId accepts: an HMAC of the id,
+// so a leaked token only opens one workspace. StorageFromEnv never enables the agent without a Secret.
+func (a FileAgent) Token(workspaceId string) string {
+	if a.Secret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(a.Secret))
+	mac.Write([]byte(workspaceId))
+	return hex.EncodeToString(mac.Sum(nil))
+}
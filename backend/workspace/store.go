@@ -0,0 +1,109 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrNotFound = errors.New("workspace not found")
+
+// ErrConflict means the record changed since the caller read it
+var ErrConflict = errors.New("workspace changed concurrently")
+
+type State string
+
+const (
+	StateProvisioning State = "provisioning"
+	StateRunning      State = "running"
+	StateSuspended    State = "suspended"
+	StateStopped      State = "stopped"
+	// StateFailed marks a workspace whose provisioning failed or was cancelled; the reaper
+	// removes it unless it ran before
+	StateFailed State = "failed"
+)
+
+// Resource is a Kubernetes object created for a workspace
+type Resource struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// Record is everything needed to find and clean up a workspace after a restart.
+// Plan is the catalogue resource profile it was created with; FileAgent is set
+// while its pod runs the file agent sidecar; Started is set once it first runs.
+// Revision counts the writes to the record; the store sets it on every write.
+type Record struct {
+	ID          string         `json:"id"`
+	Owner       string         `json:"owner"`
+	ProjectType string         `json:"projectType"`
+	Plan        string         `json:"plan,omitempty"`
+	PodName     string         `json:"podName"`
+	Storage     StorageBackend `json:"storage,omitempty"`
+	FileAgent   bool           `json:"fileAgent,omitempty"`
+	Started     bool           `json:"started,omitempty"`
+	Resources   []Resource     `json:"resources"`
+	State       State          `json:"state"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+	Revision    uint64         `json:"revision"`
+}
+
+// StorageBackend is where the workspace's files live; records from before
+// storage backends existed are hostpath
+func (r *Record) StorageBackend() StorageBackend {
+	if r.Storage == "" {
+		return StorageHostPath
+	}
+	return r.Storage
+}
+
+// NeverRan reports whether a failed or provisioning workspace never reached running, so it
+// holds nothing of the user's worth saving
+func (r *Record) NeverRan() bool {
+	return !r.Started && (r.State == StateFailed || r.State == StateProvisioning)
+}
+
+// Store persists workspace records. Get returns ErrNotFound for unknown ids.
+// Put overwrites unconditionally; writers racing with others read-modify-write through
+// Update, which runs fn on the latest record and saves the result atomically (an error
+// from fn aborts it), and delete through CompareAndDelete, which fails with ErrConflict
+// once the record moved past revision.
+type Store interface {
+	Get(id string) (*Record, error)
+	Put(rec *Record) error
+	Update(id string, fn func(rec *Record) error) (*Record, error)
+	Delete(id string) error
+	CompareAndDelete(id string, revision uint64) error
+	List() ([]*Record, error)
+	Close() error
+}
+
+// NewStoreFromEnv opens the store selected by STORE_DRIVER (bolt by default).
+// The bolt file lives at STORE_PATH, or CACHE_DIR/workspaces.db if unset.
+func NewStoreFromEnv() (Store, error) {
+	driver := os.Getenv("STORE_DRIVER")
+	switch driver {
+	case "", "bolt":
+		path := os.Getenv("STORE_PATH")
+		if path == "" {
+			path = filepath.Join(os.Getenv("CACHE_DIR"), "workspaces.db")
+		}
+		return NewBoltStore(path)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unsupported STORE_DRIVER: %s", driver)
+	}
+}
+
+// IsOwner is false for unknown workspaces or on lookup errors
+func IsOwner(store Store, id string, userId string) bool {
+	rec, err := store.Get(id)
+	if err != nil {
+		return false
+	}
+	return rec.Owner == userId
+}
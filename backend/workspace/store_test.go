@@ -0,0 +1,210 @@
+package workspace
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stores returns a fresh instance of every Store implementation
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	bolt, err := NewBoltStore(filepath.Join(t.TempDir(), "workspaces.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+	return map[string]Store{"memory": NewMemoryStore(), "bolt": bolt}
+}
+
+func testRecord(id string) *Record {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &Record{
+		ID:          id,
+		Owner:       "user-1",
+		ProjectType: "golang",
+		Plan:        "small",
+		PodName:     "shell-" + id,
+		Storage:     StorageVolume,
+		Resources:   []Resource{{Kind: "Pod", Name: "shell-" + id}, {Kind: "Service", Name: "svc-" + id}},
+		State:       StateRunning,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			want := testRecord("abc")
+			if err := store.Put(want); err != nil {
+				t.Fatal(err)
+			}
+			got, err := store.Get("abc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Get = %+v, want %+v", got, want)
+			}
+
+			//changing a returned record mustn't change the stored one:
+			got.State = StateProvisioning
+			got.Resources[0].Name = "changed"
+			again, _ := store.Get("abc")
+			if !reflect.DeepEqual(again, want) {
+				t.Errorf("stored record changed through a returned copy: %+v", again)
+			}
+
+			want.State = StateStopped
+			if err := store.Put(want); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := store.Get("abc"); got.State != StateStopped {
+				t.Errorf("Put didn't overwrite, state %s", got.State)
+			}
+		})
+	}
+}
+
+func TestStoreNotFound(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.Get("missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get missing = %v, want ErrNotFound", err)
+			}
+			if err := store.Put(testRecord("abc")); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete("abc"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Get("abc"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get deleted = %v, want ErrNotFound", err)
+			}
+			if err := store.Delete("abc"); err != nil {
+				t.Errorf("deleting twice: %v", err)
+			}
+			if IsOwner(store, "abc", "user-1") {
+				t.Error("IsOwner true for a deleted workspace")
+			}
+		})
+	}
+}
+
+func TestStoreList(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			list, err := store.List()
+			if err != nil || len(list) != 0 {
+				t.Fatalf("List on empty store = %v, %v", list, err)
+			}
+			for _, id := range []string{"c", "a", "b"} {
+				if err := store.Put(testRecord(id)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store.Delete("b")
+
+			list, err = store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, rec := range list {
+				ids = append(ids, rec.ID)
+			}
+			if !reflect.DeepEqual(ids, []string{"a", "c"}) {
+				t.Errorf("List ids = %v, want [a c]", ids)
+			}
+		})
+	}
+}
+
+func TestBoltStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workspaces.db")
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testRecord("abc")
+	if err := store.Put(want); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	got, err := store.Get("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("record after reopen = %+v", got)
+	}
+}
+
+func TestStoreRevisions(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			rec := testRecord("abc")
+			if err := store.Put(rec); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Revision != 1 {
+				t.Errorf("revision after the first Put = %d, want 1", rec.Revision)
+			}
+			stale := *rec
+			if err := store.Put(&stale); err != nil {
+				t.Fatal(err)
+			}
+			if stale.Revision != 2 {
+				t.Errorf("Put of a stale copy set revision %d, want 2", stale.Revision)
+			}
+
+			updated, err := store.Update("abc", func(cur *Record) error {
+				cur.State = StateSuspended
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.State != StateSuspended || updated.Revision != 3 {
+				t.Errorf("Update returned %s at revision %d, want suspended at 3", updated.State, updated.Revision)
+			}
+
+			//an aborted update writes nothing:
+			_, err = store.Update("abc", func(cur *Record) error {
+				cur.State = StateRunning
+				return ErrConflict
+			})
+			if err != ErrConflict {
+				t.Errorf("aborted Update = %v, want ErrConflict", err)
+			}
+			if got, _ := store.Get("abc"); got.State != StateSuspended || got.Revision != 3 {
+				t.Errorf("after an aborted Update: %s at revision %d", got.State, got.Revision)
+			}
+			if _, err := store.Update("missing", func(*Record) error { return nil }); err != ErrNotFound {
+				t.Errorf("Update missing = %v, want ErrNotFound", err)
+			}
+
+			if err := store.CompareAndDelete("abc", 2); err != ErrConflict {
+				t.Errorf("CompareAndDelete at an old revision = %v, want ErrConflict", err)
+			}
+			if _, err := store.Get("abc"); err != nil {
+				t.Errorf("record deleted at an old revision: %v", err)
+			}
+			if err := store.CompareAndDelete("abc", 3); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Get("abc"); err != ErrNotFound {
+				t.Errorf("Get after CompareAndDelete = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
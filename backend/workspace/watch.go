@@ -0,0 +1,116 @@
+package workspace
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+const (
+	EventCreate = "create"
+	EventWrite  = "write"
+	EventRemove = "remove"
+)
+
+// Event reports a change to a file or folder, by client path
+type Event struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+}
+
+// WatchInterval is how often FS.Watch rescans the tree
+var WatchInterval = time.Second
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	isDir   bool
+}
+
+// Watch polls the tree below clientPath (skipping node_modules and .git) and reports
+// every difference between two scans
+func (f *FS) Watch(ctx context.Context, clientPath string) (<-chan Event, error) {
+	dir, err := f.Resolve(clientPath)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := f.scan(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(WatchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			next, err := f.scan(dir)
+			if err != nil {
+				//the folder itself may be gone; report what disappeared and keep polling
+				next = map[string]fileState{}
+			}
+			for _, ev := range diff(prev, next) {
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+			prev = next
+		}
+	}()
+	return events, nil
+}
+
+func (f *FS) scan(dir string) (map[string]fileState, error) {
+	states := make(map[string]fileState)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir {
+				return err
+			}
+			return nil
+		}
+		if p == dir {
+			return nil
+		}
+		if d.IsDir() && (d.Name() == "node_modules" || d.Name() == ".git") {
+			return filepath.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		states[f.Rel(p)] = fileState{modTime: info.ModTime(), size: info.Size(), isDir: d.IsDir()}
+		return nil
+	})
+	return states, err
+}
+
+func diff(prev map[string]fileState, next map[string]fileState) []Event {
+	var events []Event
+	for p, state := range next {
+		old, ok := prev[p]
+		switch {
+		case !ok:
+			events = append(events, Event{Op: EventCreate, Path: p})
+		case !state.isDir && (!state.modTime.Equal(old.modTime) || state.size != old.size):
+			events = append(events, Event{Op: EventWrite, Path: p})
+		}
+	}
+	for p := range prev {
+		if _, ok := next[p]; !ok {
+			events = append(events, Event{Op: EventRemove, Path: p})
+		}
+	}
+	return events
+}
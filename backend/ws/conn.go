@@ -0,0 +1,153 @@
+package ws
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait bounds every write; a peer that doesn't take a frame in time is dropped
+	writeWait = 10 * time.Second
+	// pongWait is how long the connection may stay silent, pings included, before it is dead
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait so a live peer always answers in time
+	pingPeriod = pongWait * 9 / 10
+	// sendQueue is how many outbound frames a connection buffers
+	sendQueue = 64
+	// sendWait is how long a sender blocks on a full queue before the connection is dropped
+	sendWait = 5 * time.Second
+)
+
+var errConnClosed = errors.New("websocket connection closed")
+
+// Conn serializes writes to a websocket: gorilla/websocket allows one writer at a time, so
+// every frame goes through a bounded queue drained by a single writer goroutine, which
+// also pings the peer. Reads extend the read deadline, so a dead peer ends the read loop.
+type Conn struct {
+	ws   *websocket.Conn
+	send chan []byte
+
+	done      chan struct{}
+	closeOnce sync.Once
+	// stopped is closed when the writer has closed the underlying connection
+	stopped chan struct{}
+}
+
+func NewConn(ws *websocket.Conn) *Conn {
+	c := &Conn{
+		ws:      ws,
+		send:    make(chan []byte, sendQueue),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	go c.writeLoop()
+	return c
+}
+
+// Subprotocol is the protocol version negotiated during the handshake
+func (c *Conn) Subprotocol() string {
+	return c.ws.Subprotocol()
+}
+
+// ReadMessage reads the next frame; only the read loop may call it
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	messageType, msg, err := c.ws.ReadMessage()
+	if err == nil {
+		c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	}
+	return messageType, msg, err
+}
+
+// Write queues a text frame. It blocks while the queue is full, and drops the connection
+// when the peer falls behind by more than sendWait. msg must not be modified afterwards.
+func (c *Conn) Write(msg []byte) error {
+	select {
+	case <-c.done:
+		return errConnClosed
+	default:
+	}
+	select {
+	case c.send <- msg:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(sendWait)
+	defer timer.Stop()
+	select {
+	case c.send <- msg:
+		return nil
+	case <-c.done:
+		return errConnClosed
+	case <-timer.C:
+		c.abort()
+		return errors.New("websocket peer too slow, connection dropped")
+	}
+}
+
+// Close stops the writer once the frames already queued are sent, and closes the connection
+func (c *Conn) Close() {
+	c.closeOnce.Do(func() { close(c.done) })
+	<-c.stopped
+}
+
+// abort drops the connection without sending what is queued: the peer stopped reading, so
+// a flush would only block the writer for writeWait per frame
+func (c *Conn) abort() {
+	c.closeOnce.Do(func() { close(c.done) })
+	//closing the connection fails the write in progress and any left, Close is safe to call
+	//alongside them:
+	c.ws.Close()
+	<-c.stopped
+}
+
+func (c *Conn) writeLoop() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+		close(c.stopped)
+	}()
+
+	for {
+		select {
+		case msg := <-c.send:
+			if err := c.write(websocket.TextMessage, msg); err != nil {
+				c.closeOnce.Do(func() { close(c.done) })
+				return
+			}
+		case <-ticker.C:
+			if err := c.write(websocket.PingMessage, nil); err != nil {
+				c.closeOnce.Do(func() { close(c.done) })
+				return
+			}
+		case <-c.done:
+			//flush what was queued before the close:
+			for {
+				select {
+				case msg := <-c.send:
+					if err := c.write(websocket.TextMessage, msg); err != nil {
+						return
+					}
+				default:
+					c.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *Conn) write(messageType int, msg []byte) error {
+	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteMessage(messageType, msg)
+}
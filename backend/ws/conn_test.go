@@ -0,0 +1,54 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestConnSlowPeer checks a peer that stops reading is dropped after sendWait, without the
+// frames still queued being flushed to it first
+func TestConnSlowPeer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits sendWait for the queue to time out")
+	}
+	done := make(chan time.Duration, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn := NewConn(ws)
+		defer conn.Close()
+		frame := make([]byte, 1<<20)
+		for {
+			start := time.Now()
+			if conn.Write(frame) != nil {
+				done <- time.Since(start)
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	//the client never reads, so the socket buffers fill and the writer blocks:
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	select {
+	case elapsed := <-done:
+		if elapsed > sendWait+time.Second {
+			t.Errorf("Write took %v to drop the peer, want about sendWait (%v) with no flush", elapsed, sendWait)
+		}
+	case <-time.After(sendWait + 2*writeWait):
+		t.Fatal("slow peer not dropped")
+	}
+}
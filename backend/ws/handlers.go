@@ -2,29 +2,40 @@ package ws
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"math/rand"
 	"os"
-	"path/filepath"
+	"path"
+	"sync"
 	"time"
 
 	"github.com/go-playground/validator/v10"
-	"github.com/gorilla/websocket"
+	"github.com/mudit06mah/CloudIde/agent"
+	"github.com/mudit06mah/CloudIde/auth"
 	"github.com/mudit06mah/CloudIde/aws"
+	"github.com/mudit06mah/CloudIde/catalog"
 	"github.com/mudit06mah/CloudIde/k8s"
+	"github.com/mudit06mah/CloudIde/workspace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // --- Structs ---
 
+// Response answers a Message; ID and Type are copied from it
 type Response struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type,omitempty"`
 	Success bool            `json:"success"`
 	Message string          `json:"message"`
 	Payload json.RawMessage `json:"payload,omitempty"`
 }
 
 type Message struct {
+	// ID is optional; it is echoed on the response, and a request with one can be
+	// aborted with a cancel message
+	ID      string          `json:"id,omitempty"`
 	Type    string          `json:"type"`
 	Payload json.RawMessage `json:"payload"`
 }
@@ -36,24 +47,25 @@ type FileNode struct {
 	Path     string     `json:"path"`
 }
 
+type ProjectPayload struct {
+	WorkspaceId string   `json:"workspaceId"`
+	Tree        FileNode `json:"fileNode"`
+}
+
 // WSWriter adapter for K8s exec
 type WSWriter struct {
-	Conn *websocket.Conn
+	Session *Session
+	// ctx is the requestTerminal request the output belongs to
+	ctx context.Context
 }
 
 func (w *WSWriter) Write(p []byte) (n int, err error) {
-	response := Response{
-		Success: true,
-		Message: "terminal:output",
-		Payload: json.RawMessage(fmt.Sprintf("%q", string(p))),
-	}
-
-	msg, err := json.Marshal(response)
+	payload, err := json.Marshal(string(p))
 	if err != nil {
 		return 0, err
 	}
 
-	err = w.Conn.WriteMessage(websocket.TextMessage, msg)
+	err = w.Session.sendEvent(w.ctx, "terminal:output", payload)
 	if err != nil {
 		return 0, err
 	}
@@ -67,60 +79,311 @@ var validate = validator.New()
 
 const namespace = "cloud-ide"
 
-// Session holds the state for ONE specific user connection
+// MaxInFlight is how many messages a session handles at once; more are rejected until one finishes
+const MaxInFlight = 8
+
+// orderedMessages change files, so they run one after another in the order they arrived
+var orderedMessages = map[string]bool{
+	"createFile":   true,
+	"updateFile":   true,
+	"deleteFile":   true,
+	"createFolder": true,
+	"deleteFolder": true,
+	"renamePath":   true,
+}
+
+// Session holds the state for ONE specific user connection. Messages are handled
+// concurrently, so the workspace fields are only touched with mu held.
 type Session struct {
 	ProjectType string
-	Conn        *websocket.Conn
+	Conn        *Conn
 	WorkspaceID string
 	K8sClient   *k8s.Client
+	FS          workspace.FileService
+	User        *auth.Identity
+	// Protocol is the version negotiated when the connection was opened
+	Protocol string
+
+	server *Server
+	// ctx is cancelled when the connection closes, and with it every message still running
+	ctx    context.Context
+	cancel context.CancelFunc
+	// mu guards WorkspaceID, ProjectType, FS and the watches below
+	mu sync.Mutex
+	// stopWatch ends the file watch of the current workspace
+	stopWatch context.CancelFunc
+	// stopStatus ends the pod status updates of the current workspace
+	stopStatus context.CancelFunc
+	// slots limits the messages in flight; inflight cancels them by request id
+	slots      chan struct{}
+	inflightMu sync.Mutex
+	inflight   map[string]context.CancelFunc
+	// lastFileOp is closed when the latest ordered message is done; only the read loop sets it
+	lastFileOp chan struct{}
 }
 
-func NewSession(conn *websocket.Conn) *Session {
+func NewSession(conn *Conn, server *Server, user *auth.Identity) *Session {
+	ctx, cancel := context.WithCancel(context.Background())
+	protocol := conn.Subprotocol()
+	if protocol == "" {
+		protocol = ProtocolV1
+	}
 	return &Session{
-		Conn: conn,
+		Conn:      conn,
+		User:      user,
+		Protocol:  protocol,
+		K8sClient: server.K8s,
+		server:    server,
+		ctx:       ctx,
+		cancel:    cancel,
+		slots:     make(chan struct{}, MaxInFlight),
+		inflight:  make(map[string]context.CancelFunc),
 	}
 }
 
-// HandleMessage routes incoming messages for THIS session
+// cluster reports whether workspaces can be run, i.e. the server has a Kubernetes client
+func (s *Session) cluster() error {
+	if s.K8sClient == nil || s.server.Status == nil {
+		return errNoCluster
+	}
+	return nil
+}
+
+// Close cancels any work still running for the session
+func (s *Session) Close() {
+	s.cancel()
+}
+
+// HandleMessage routes incoming messages for THIS session. It is called from the read loop
+// and returns right away: each message runs in its own goroutine with a context that ends
+// when the message is cancelled or the connection closes.
 func (s *Session) HandleMessage(rawMsg []byte) {
 	var msg Message
 	err := json.Unmarshal(rawMsg, &msg)
 	if err != nil {
 		fmt.Println("Error unmarshalling message:", err)
-		s.sendResponse(false, "Error unmarshalling message: "+err.Error(), nil)
+		s.sendResponse(s.ctx, false, "Error unmarshalling message: "+err.Error(), nil)
+		return
+	}
+
+	ctx := withRequest(s.ctx, msg)
+	if msg.Type == "cancel" {
+		s.handleCancel(ctx, msg.Payload)
 		return
 	}
 
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		s.sendResponse(ctx, false, "Too many requests in progress", nil)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	if msg.ID != "" {
+		s.inflightMu.Lock()
+		_, taken := s.inflight[msg.ID]
+		if !taken {
+			s.inflight[msg.ID] = cancel
+		}
+		s.inflightMu.Unlock()
+		if taken {
+			cancel()
+			<-s.slots
+			s.sendResponse(ctx, false, "Request id already in use: "+msg.ID, nil)
+			return
+		}
+	}
+
+	//file changes wait for the previous one, so they apply in the order they were sent:
+	var prev, done chan struct{}
+	if orderedMessages[msg.Type] {
+		prev, done = s.lastFileOp, make(chan struct{})
+		s.lastFileOp = done
+	}
+
+	go func() {
+		waited := prev == nil
+		defer func() {
+			switch {
+			case done == nil:
+			case waited:
+				close(done)
+			default:
+				//cancelled while queued: the next change still waits for the earlier ones
+				go func() {
+					<-prev
+					close(done)
+				}()
+			}
+			if msg.ID != "" {
+				s.inflightMu.Lock()
+				delete(s.inflight, msg.ID)
+				s.inflightMu.Unlock()
+			}
+			cancel()
+			<-s.slots
+		}()
+
+		if prev != nil {
+			select {
+			case <-prev:
+				waited = true
+			case <-ctx.Done():
+				s.sendResponse(ctx, false, "Request cancelled before it ran", nil)
+				return
+			}
+		}
+		s.dispatch(ctx, msg)
+	}()
+}
+
+func (s *Session) dispatch(ctx context.Context, msg Message) {
 	switch msg.Type {
+	case "listProjectTypes":
+		s.handleListProjectTypes(ctx, msg.Payload)
 	case "initProject":
-		s.handleCreateProject(msg.Payload)
+		s.handleCreateProject(ctx, msg.Payload)
+	case "openWorkspace":
+		s.handleOpenWorkspace(ctx, msg.Payload)
 	case "createFile":
-		s.handleCreateFile(msg.Payload)
+		s.handleCreateFile(ctx, msg.Payload)
 	case "getFile":
-		s.handleGetFile(msg.Payload)
+		s.handleGetFile(ctx, msg.Payload)
 	case "deleteFile":
-		s.handleDeleteFile(msg.Payload)
+		s.handleDeleteFile(ctx, msg.Payload)
 	case "createFolder":
-		s.handleCreateFolder(msg.Payload)
+		s.handleCreateFolder(ctx, msg.Payload)
 	case "deleteFolder":
-		s.handleDeleteFolder(msg.Payload)
+		s.handleDeleteFolder(ctx, msg.Payload)
 	case "updateFile":
-		s.handleUpdateFile(msg.Payload)
+		s.handleUpdateFile(ctx, msg.Payload)
 	case "requestTerminal":
-		s.handleRequestTerminal(msg.Payload)
+		s.handleRequestTerminal(ctx, msg.Payload)
 	case "getTree":
-		s.handleGetTree(msg.Payload)
+		s.handleGetTree(ctx, msg.Payload)
+	case "renamePath":
+		s.handleRenamePath(ctx, msg.Payload)
 	case "stopWorkspace":
-		s.handleStopWorkspace(msg.Payload)
+		s.handleStopWorkspace(ctx, msg.Payload)
 	default:
 		fmt.Println("Unknown message type:", msg.Type)
-		s.sendResponse(false, "Unknown message type: "+msg.Type, nil)
+		s.sendResponse(ctx, false, "Unknown message type: "+msg.Type, nil)
 	}
 }
 
+// handleCancel aborts the in-flight request with the given id
+func (s *Session) handleCancel(ctx context.Context, payload json.RawMessage) {
+	var data struct {
+		ID string `json:"id" validate:"required"`
+	}
+	if err := json.Unmarshal(payload, &data); err != nil {
+		s.sendResponse(ctx, false, "Error unmarshalling", nil)
+		return
+	}
+	if err := validate.Struct(data); err != nil {
+		s.sendResponse(ctx, false, "Validation error: "+err.Error(), nil)
+		return
+	}
+
+	s.inflightMu.Lock()
+	cancel, ok := s.inflight[data.ID]
+	s.inflightMu.Unlock()
+	if !ok {
+		s.sendResponse(ctx, false, "No request in progress with id "+data.ID, nil)
+		return
+	}
+	cancel()
+	s.sendResponse(ctx, true, "Request cancelled", nil)
+}
+
+// current is the session's workspace id
+func (s *Session) current() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.WorkspaceID
+}
+
+// setWorkspace switches the session to another workspace; its files are looked up again
+func (s *Session) setWorkspace(id string, projectType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.WorkspaceID = id
+	s.ProjectType = projectType
+	s.FS = nil
+}
+
 // helper functions:
-func (s *Session) sendResponse(success bool, message string, payload json.RawMessage) {
+func (s *Session) owns(workspaceId string) bool {
+	return workspaceId != "" && workspace.IsOwner(s.server.Store, workspaceId, s.User.UserID)
+}
+
+func (s *Session) updateRecord(record *workspace.Record) {
+	record.UpdatedAt = time.Now()
+	if err := s.server.Store.Put(record); err != nil {
+		fmt.Println("Error updating workspace record:", err)
+	}
+}
+
+func (s *Session) workspaceFS(ctx context.Context) (workspace.FileService, error) {
+	s.mu.Lock()
+	id, fsys := s.WorkspaceID, s.FS
+	s.mu.Unlock()
+
+	if id == "" {
+		return nil, fmt.Errorf("no workspace initialized")
+	}
+	if fsys == nil {
+		record, err := s.server.Store.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if fsys, err = s.filesFor(ctx, record); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		if s.WorkspaceID == id && s.FS == nil {
+			s.FS = fsys
+		}
+		s.mu.Unlock()
+	}
+	s.server.Activity.Touch(id)
+	return fsys, nil
+}
+
+// filesFor returns the file service for the workspace: its file agent when the pod runs one,
+// otherwise the local cache (hostpath) or commands exec'd in the pod (volume)
+func (s *Session) filesFor(ctx context.Context, record *workspace.Record) (workspace.FileService, error) {
+
+	if record.StorageBackend() == workspace.StorageHostPath && !record.FileAgent {
+		return workspace.NewFS(record.ID), nil
+	}
+
+	if err := s.cluster(); err != nil {
+		return nil, err
+	}
+
+	if !record.FileAgent {
+		return k8s.NewPodFS(s.K8sClient, record.PodName), nil
+	}
+
+	//the agent is reached on the pod IP, so the backend has to run inside the cluster:
+	pod, err := s.K8sClient.Clientset.CoreV1().Pods(namespace).Get(ctx, record.PodName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error finding pod: %v", err)
+	}
+	if pod.Status.PodIP == "" {
+		return nil, fmt.Errorf("pod %s has no IP yet", record.PodName)
+	}
+	return agent.NewClient(pod.Status.PodIP, s.server.Storage.Agent.Token(record.ID)), nil
+}
+
+// sendResponse answers the request handled under ctx
+func (s *Session) sendResponse(ctx context.Context, success bool, message string, payload json.RawMessage) {
+	req := requestFrom(ctx)
 	response := Response{
+		ID:      req.ID,
+		Type:    req.Type,
 		Success: success,
 		Message: message,
 		Payload: payload,
@@ -130,82 +393,459 @@ func (s *Session) sendResponse(success bool, message string, payload json.RawMes
 		fmt.Println("Error marshalling response:", err)
 		return
 	}
-	err = s.Conn.WriteMessage(websocket.TextMessage, respBytes)
+	err = s.writeMessage(respBytes)
 	if err != nil {
 		fmt.Println("Error sending response:", err)
 	}
 }
 
-func createWorkspaceId(size int) string {
-	charset := "abcdefghijklmnopqrstuvwxyz0123456789"
-	id := ""
-	for i := 0; i < size; i++ {
-		id += string(charset[rand.Intn(len(charset))])
+// sendEvent pushes data to the client, tagged with the request under ctx if there is one.
+// v1 clients get it as a successful response whose message is the event name.
+func (s *Session) sendEvent(ctx context.Context, event string, payload json.RawMessage) error {
+	var frame any = Event{Event: event, ID: requestFrom(ctx).ID, Payload: payload}
+	if s.Protocol == ProtocolV1 {
+		frame = Response{Success: true, Message: event, Payload: payload}
+	}
+	msg, err := json.Marshal(frame)
+	if err != nil {
+		return err
 	}
-	return id
+	return s.writeMessage(msg)
 }
 
-func (s *Session) handleCreateProject(payload json.RawMessage) {
+func (s *Session) writeMessage(msg []byte) error {
+	return s.Conn.Write(msg)
+}
+
+// createWorkspaceId draws size characters from crypto/rand: ids are store keys ownership checks
+// rely on, so they must not be predictable
+func createWorkspaceId(size int) (string, error) {
+	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
+	//bytes past the last whole multiple of the charset are skipped, keeping every character equally likely:
+	limit := 256 - 256%len(charset)
+	id := make([]byte, 0, size)
+	buf := make([]byte, size)
+	for len(id) < size {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(id) < size {
+				id = append(id, charset[int(b)%len(charset)])
+			}
+		}
+	}
+	return string(id), nil
+}
+
+// newWorkspaceId returns a random id no record uses yet
+func (s *Session) newWorkspaceId() (string, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		id, err := createWorkspaceId(10)
+		if err != nil {
+			return "", err
+		}
+		if _, err := s.server.Store.Get(id); err == workspace.ErrNotFound {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("no unused workspace id found")
+}
+
+// handleListProjectTypes lets the frontend render the catalogue's project types
+func (s *Session) handleListProjectTypes(ctx context.Context, payload json.RawMessage) {
+	type ProjectTypeInfo struct {
+		Name        string         `json:"name"`
+		DisplayName string         `json:"displayName"`
+		Ports       []catalog.Port `json:"ports,omitempty"`
+		// Plans the user may pick for this type, the default first
+		Plans []string `json:"plans,omitempty"`
+	}
+
+	var types []ProjectTypeInfo
+	for _, pt := range s.server.Catalog.List() {
+		var plans []string
+		for _, profile := range s.allowedProfiles(pt) {
+			plans = append(plans, profile.Name)
+		}
+		types = append(types, ProjectTypeInfo{Name: pt.Name, DisplayName: pt.DisplayName, Ports: pt.Ports, Plans: plans})
+	}
+
+	var plans []catalog.Profile
+	for _, profile := range s.server.Catalog.Profiles() {
+		if s.User.Allows(profile.Name) {
+			plans = append(plans, profile)
+		}
+	}
+
+	resp, err := json.Marshal(struct {
+		ProjectTypes []ProjectTypeInfo `json:"projectTypes"`
+		Plans        []catalog.Profile `json:"plans,omitempty"`
+	}{ProjectTypes: types, Plans: plans})
+	if err != nil {
+		s.sendResponse(ctx, false, "Error marshalling project types: "+err.Error(), nil)
+		return
+	}
+	s.sendResponse(ctx, true, "Project types retrieved successfully", resp)
+}
+
+// allowedProfiles are the profiles of a project type the session's user has a plan for
+func (s *Session) allowedProfiles(projectType catalog.ProjectType) []catalog.Profile {
+	var allowed []catalog.Profile
+	for _, profile := range s.server.Catalog.ProfilesFor(projectType) {
+		if s.User.Allows(profile.Name) {
+			allowed = append(allowed, profile)
+		}
+	}
+	return allowed
+}
+
+// choosePlan validates the requested plan, or picks the first allowed one (the default if
+// allowed) when none was asked for. Catalogues without profiles take no plan.
+func (s *Session) choosePlan(projectType catalog.ProjectType, plan string) (string, error) {
+	if len(s.server.Catalog.Profiles()) == 0 {
+		if plan != "" {
+			return "", fmt.Errorf("unknown plan %s", plan)
+		}
+		return "", nil
+	}
+
+	allowed := s.allowedProfiles(projectType)
+	if plan == "" {
+		if len(allowed) == 0 {
+			return "", fmt.Errorf("no plan available for project type %s", projectType.Name)
+		}
+		return allowed[0].Name, nil
+	}
+
+	for _, profile := range allowed {
+		if profile.Name == plan {
+			return plan, nil
+		}
+	}
+	if _, ok := s.server.Catalog.Profile(plan); !ok {
+		return "", fmt.Errorf("unknown plan %s", plan)
+	}
+	return "", fmt.Errorf("plan %s is not available for project type %s", plan, projectType.Name)
+}
+
+func (s *Session) handleCreateProject(ctx context.Context, payload json.RawMessage) {
 	type CreateProjectData struct {
-		ProjectType string `json:"projectType" validate:"required,oneof=python nodejs golang cpp react"`
+		ProjectType string `json:"projectType" validate:"required"`
+		Plan        string `json:"plan"`
 	}
 	var data CreateProjectData
 	if err := json.Unmarshal(payload, &data); err != nil {
-		s.sendResponse(false, "Error unmarshalling", nil)
+		s.sendResponse(ctx, false, "Error unmarshalling", nil)
 		return
 	}
 	if err := validate.Struct(data); err != nil {
-		s.sendResponse(false, "Validation error: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Validation error: "+err.Error(), nil)
+		return
+	}
+	projectType, ok := s.server.Catalog.Get(data.ProjectType)
+	if !ok {
+		s.sendResponse(ctx, false, "Validation error: unsupported project type "+data.ProjectType, nil)
+		return
+	}
+	plan, err := s.choosePlan(projectType, data.Plan)
+	if err != nil {
+		s.sendResponse(ctx, false, "Validation error: "+err.Error(), nil)
+		return
+	}
+
+	id, err := s.newWorkspaceId()
+	if err != nil {
+		s.sendResponse(ctx, false, "Error creating workspace id: "+err.Error(), nil)
+		return
+	}
+	s.setWorkspace(id, data.ProjectType)
+
+	record := &workspace.Record{
+		ID:          id,
+		Owner:       s.User.UserID,
+		ProjectType: data.ProjectType,
+		Plan:        plan,
+		PodName:     fmt.Sprintf("shell-%s", id),
+		Storage:     s.server.Storage.Backend,
+		State:       workspace.StateProvisioning,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := s.server.Store.Put(record); err != nil {
+		s.sendResponse(ctx, false, "Error recording workspace: "+err.Error(), nil)
+		return
+	}
+	//the template always lands in the local cache; volume backed workspaces copy it into their pod once it's up:
+	localDir := workspace.NewFS(id).Root
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		s.failProvisioning(ctx, record, "Error creating cache dir: "+err.Error())
 		return
 	}
 
-	s.WorkspaceID = createWorkspaceId(10)
-	s.ProjectType = data.ProjectType
-	currentCachePath := filepath.Join(os.Getenv("CACHE_DIR"), s.WorkspaceID)
+	s.sendProgress(ctx, record.ID, StageTemplateDownloading, "Downloading the "+projectType.Template+" template")
+	if err := s.server.Templates.Fetch(ctx, projectType.Template, localDir); err != nil {
+		s.failProvisioning(ctx, record, "Error downloading template: "+err.Error())
+		return
+	}
 
-	if err := os.MkdirAll(currentCachePath, 0755); err != nil {
-		s.sendResponse(false, "Error creating cache dir: "+err.Error(), nil)
+	if err := s.cluster(); err != nil {
+		s.failProvisioning(ctx, record, err.Error())
 		return
 	}
 
-	if _, err := aws.DownloadTemplate(data.ProjectType, s.WorkspaceID); err != nil {
-		s.sendResponse(false, "Error downloading template: "+err.Error(), nil)
+	if err := s.applyResources(ctx, record); err != nil {
+		s.failProvisioning(ctx, record, "Error obtaining manifests: "+err.Error())
 		return
 	}
+	s.sendProgress(ctx, record.ID, StageManifestsApplied, fmt.Sprintf("%d objects applied", len(record.Resources)))
 
-	var err error
-	s.K8sClient, err = k8s.NewK8sClient(s.WorkspaceID)
+	s.watchStatus(id)
+	status, err := s.waitForPod(ctx, id)
 	if err != nil {
-		s.sendResponse(false, "Error creating k8s client: "+err.Error(), nil)
+		s.failProvisioning(ctx, record, "Error waiting for pod: "+err.Error())
 		return
 	}
 
-	ctx := context.Background()
-	manifests, err := s.K8sClient.RenderProjectResources(data.ProjectType)
+	record.PodName = status.Pod
+	fsys, err := s.loadFiles(ctx, record, localDir)
 	if err != nil {
-		s.sendResponse(false, "Error obtaining manifests: "+err.Error(), nil)
+		s.failProvisioning(ctx, record, err.Error())
 		return
 	}
-	for _, manifest := range manifests {
-		s.K8sClient.ApplyManifest(ctx, manifest)
+	record.State = workspace.StateRunning
+	record.Started = true
+	s.updateRecord(record)
+
+	s.sendProject(ctx, "Project created successfully", record.ID, fsys)
+}
+
+// handleOpenWorkspace reattaches the session to a workspace the user already owns,
+// recreating its pod if it has been reaped
+func (s *Session) handleOpenWorkspace(ctx context.Context, payload json.RawMessage) {
+	var data struct {
+		WorkspaceId string `json:"workspaceId" validate:"required"`
+	}
+	if err := json.Unmarshal(payload, &data); err != nil {
+		s.sendResponse(ctx, false, "Error unmarshalling", nil)
+		return
+	}
+	if err := validate.Struct(data); err != nil {
+		s.sendResponse(ctx, false, "Validation error: "+err.Error(), nil)
+		return
 	}
 
-	_, err = s.K8sClient.WaitForPodByLabel(ctx, namespace, fmt.Sprintf("workspace=%s", s.WorkspaceID), 300*time.Second)
+	record, err := s.server.Store.Get(data.WorkspaceId)
+	if err != nil || record.Owner != s.User.UserID {
+		s.sendResponse(ctx, false, "Workspace not found", nil)
+		return
+	}
+
+	localDir := workspace.NewFS(record.ID).Root
+	_, statErr := os.Stat(localDir)
+	//stopped workspaces come back from their last snapshot, restored locally first:
+	restore := os.IsNotExist(statErr)
+	if record.StorageBackend() == workspace.StorageVolume {
+		restore = record.State == workspace.StateStopped
+	}
+	if restore {
+		if err := aws.RestoreSnapshot(ctx, record.ID); err != nil {
+			s.sendResponse(ctx, false, "Workspace files not found: "+err.Error(), nil)
+			return
+		}
+	}
+
+	if err := s.cluster(); err != nil {
+		s.sendResponse(ctx, false, err.Error(), nil)
+		return
+	}
+
+	s.setWorkspace(record.ID, record.ProjectType)
+	s.server.Activity.Touch(record.ID)
+
+	s.watchStatus(record.ID)
+	status, ok := s.server.Status.Get(record.ID)
+	if !ok || !status.Ready {
+		//pod is gone (or not ready yet), applying again brings it back and is a no-op otherwise:
+		record.State = workspace.StateProvisioning
+		if err := s.applyResources(ctx, record); err != nil {
+			s.failProvisioning(ctx, record, "Error obtaining manifests: "+err.Error())
+			return
+		}
+		s.sendProgress(ctx, record.ID, StageManifestsApplied, fmt.Sprintf("%d objects applied", len(record.Resources)))
+
+		status, err = s.waitForPod(ctx, record.ID)
+		if err != nil {
+			s.failProvisioning(ctx, record, "Error waiting for pod: "+err.Error())
+			return
+		}
+	}
+
+	record.PodName = status.Pod
+	if !restore {
+		localDir = ""
+	}
+	fsys, err := s.loadFiles(ctx, record, localDir)
 	if err != nil {
-		s.sendResponse(false, "Error waiting for pod: "+err.Error(), nil)
+		s.failProvisioning(ctx, record, err.Error())
 		return
 	}
+	record.State = workspace.StateRunning
+	record.Started = true
+	s.updateRecord(record)
+
+	s.sendProject(ctx, "Workspace opened successfully", record.ID, fsys)
+}
 
-	tree, _ := generateTree(currentCachePath, s.WorkspaceID)
-	type ProjectPayload struct {
-		WorkspaceId string   `json:"workspaceId"`
-		Tree        FileNode `json:"fileNode"`
+// loadFiles returns the workspace's files once its pod is up, and points the session at
+// them unless it has moved on to another workspace meanwhile. Volume backed workspaces get
+// localDir (when set) copied into the pod, after which the local copy is dropped.
+func (s *Session) loadFiles(ctx context.Context, record *workspace.Record, localDir string) (workspace.FileService, error) {
+	if localDir != "" && record.StorageBackend() == workspace.StorageVolume {
+		if err := k8s.NewPodFS(s.K8sClient, record.PodName).CopyIn(ctx, localDir); err != nil {
+			return nil, fmt.Errorf("Error copying files into workspace: %v", err)
+		}
+		if err := os.RemoveAll(localDir); err != nil {
+			fmt.Println("Error deleting cache:", err)
+		}
+	}
+
+	fsys, err := s.filesFor(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+	s.watchFiles(record.ID, fsys)
+	return fsys, nil
+}
+
+// watchFiles makes fsys the session's files and forwards their changes as "File changed"
+// messages, replacing any previous watch. It does nothing once the session no longer
+// points at workspaceId.
+func (s *Session) watchFiles(workspaceId string, fsys workspace.FileService) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.WorkspaceID != workspaceId {
+		return
 	}
-	response, _ := json.Marshal(ProjectPayload{WorkspaceId: s.WorkspaceID, Tree: tree})
-	s.sendResponse(true, "Project created successfully", response)
+	s.FS = fsys
+	if s.stopWatch != nil {
+		s.stopWatch()
+		s.stopWatch = nil
+	}
+	watcher, ok := fsys.(workspace.Watcher)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	events, err := watcher.Watch(ctx, "/")
+	if err != nil {
+		cancel()
+		fmt.Println("Error watching workspace files:", err)
+		return
+	}
+	s.stopWatch = cancel
+
+	go func() {
+		for ev := range events {
+			payload, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			s.sendEvent(s.ctx, "File changed", payload)
+		}
+	}()
+}
+
+// watchStatus forwards pod status changes of the session's workspace as "Workspace status"
+// messages, starting with the current status and replacing any previous subscription.
+// It does nothing once the session no longer points at workspaceId.
+func (s *Session) watchStatus(workspaceId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.WorkspaceID != workspaceId {
+		return
+	}
+	if s.stopStatus != nil {
+		s.stopStatus()
+		s.stopStatus = nil
+	}
+	if s.server.Status == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.stopStatus = cancel
+	updates := s.server.Status.Subscribe(ctx, workspaceId)
+	current, ok := s.server.Status.Get(workspaceId)
+
+	go func() {
+		if ok {
+			s.sendStatus(current)
+		}
+		for status := range updates {
+			s.sendStatus(status)
+		}
+	}()
+}
+
+func (s *Session) sendStatus(status k8s.PodStatus) {
+	payload, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+	s.sendEvent(s.ctx, "Workspace status", payload)
+}
+
+// applyResources renders and applies the project's manifests, recording each object on the workspace
+func (s *Session) applyResources(ctx context.Context, record *workspace.Record) error {
+	projectType, ok := s.server.Catalog.Get(record.ProjectType)
+	if !ok {
+		return fmt.Errorf("unsupported project type: %s", record.ProjectType)
+	}
+
+	storage := s.server.Storage
+	storage.Backend = record.StorageBackend()
+	record.FileAgent = storage.Agent.Image != ""
+
+	var profile catalog.Profile
+	if record.Plan != "" {
+		if profile, ok = s.server.Catalog.Profile(record.Plan); !ok {
+			return fmt.Errorf("plan %s is no longer in the catalogue", record.Plan)
+		}
+	}
+
+	manifests, err := k8s.RenderProjectResources(projectType, k8s.RenderOptions{WorkspaceID: record.ID, Storage: storage, Profile: profile})
+	if err != nil {
+		return err
+	}
+
+	labels := k8s.WorkspaceLabels(record.ID, record.Owner)
+	record.Resources = nil
+	for _, manifest := range manifests {
+		//record resources before applying so a failed apply can still be cleaned up:
+		refs, err := k8s.ManifestObjects(manifest)
+		if err == nil {
+			for _, ref := range refs {
+				record.Resources = append(record.Resources, workspace.Resource{Kind: ref.Kind, Name: ref.Name})
+			}
+			s.updateRecord(record)
+		}
+		if _, err := s.K8sClient.ApplyManifest(ctx, manifest, labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendProject replies with a workspace's id and file tree
+func (s *Session) sendProject(ctx context.Context, message string, id string, fsys workspace.FileService) {
+	tree, _ := generateTree(ctx, fsys, "/", id)
+	response, _ := json.Marshal(ProjectPayload{WorkspaceId: id, Tree: tree})
+	s.sendResponse(ctx, true, message, response)
 }
 
-func (s *Session) handleCreateFile(payload json.RawMessage) {
+func (s *Session) handleCreateFile(ctx context.Context, payload json.RawMessage) {
 	type CreateFile struct {
 		FileName string `json:"fileName"`
 		FilePath string `json:"filePath"`
@@ -213,45 +853,50 @@ func (s *Session) handleCreateFile(payload json.RawMessage) {
 	var data CreateFile
 	if err := json.Unmarshal(payload, &data); err != nil {
 		fmt.Println("Error unmarshalling create file payload:", err)
-		s.sendResponse(false, "Error unmarshalling payload: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Error unmarshalling payload: "+err.Error(), nil)
 		return
 	}
 
-	localPath := filepath.Join(data.FilePath, data.FileName)
-	file, err := os.Create(localPath)
+	fsys, err := s.workspaceFS(ctx)
 	if err != nil {
-		fmt.Println("Error creating file:", err)
-		s.sendResponse(false, "Error creating file: "+err.Error(), nil)
+		s.sendResponse(ctx, false, err.Error(), nil)
 		return
 	}
-	if err := file.Close(); err != nil {
-		fmt.Println("Error closing file:", err)
-		s.sendResponse(false, "Error closing file: "+err.Error(), nil)
+
+	if err := fsys.Create(ctx, path.Join(data.FilePath, data.FileName)); err != nil {
+		fmt.Println("Error creating file:", err)
+		s.sendResponse(ctx, false, "Error creating file: "+err.Error(), nil)
 		return
 	}
-	s.sendResponse(true, "File created successfully", nil)
+	s.sendResponse(ctx, true, "File created successfully", nil)
 }
 
-func (s *Session) handleGetFile(payload json.RawMessage) {
+func (s *Session) handleGetFile(ctx context.Context, payload json.RawMessage) {
 	type GetFile struct {
 		FilePath string `json:"filePath"`
 	}
 	var data GetFile
 	if err := json.Unmarshal(payload, &data); err != nil {
 		fmt.Println("Error unmarshalling get file payload:", err)
-		s.sendResponse(false, "Error unmarshalling payload: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Error unmarshalling payload: "+err.Error(), nil)
 		return
 	}
 
-	if _, err := os.Stat(data.FilePath); os.IsNotExist(err) {
+	fsys, err := s.workspaceFS(ctx)
+	if err != nil {
+		s.sendResponse(ctx, false, err.Error(), nil)
+		return
+	}
+
+	content, err := fsys.ReadFile(ctx, data.FilePath)
+	if os.IsNotExist(err) {
 		fmt.Println("File does not exist:", data.FilePath)
-		s.sendResponse(false, "File does not exist", nil)
+		s.sendResponse(ctx, false, "File does not exist", nil)
 		return
 	}
-	content, err := os.ReadFile(data.FilePath)
 	if err != nil {
 		fmt.Println("Error reading file:", err)
-		s.sendResponse(false, "Error reading file: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Error reading file: "+err.Error(), nil)
 		return
 	}
 	type FileContent struct {
@@ -260,13 +905,13 @@ func (s *Session) handleGetFile(payload json.RawMessage) {
 	resp, err := json.Marshal(FileContent{Content: string(content)})
 	if err != nil {
 		fmt.Println("Error marshalling file content:", err)
-		s.sendResponse(false, "Error marshalling file content: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Error marshalling file content: "+err.Error(), nil)
 		return
 	}
-	s.sendResponse(true, "File retrieved successfully", resp)
+	s.sendResponse(ctx, true, "File retrieved successfully", resp)
 }
 
-func (s *Session) handleUpdateFile(payload json.RawMessage) {
+func (s *Session) handleUpdateFile(ctx context.Context, payload json.RawMessage) {
 	type UpdateFile struct {
 		FilePath string `json:"filePath"`
 		Content  string `json:"content"`
@@ -274,104 +919,164 @@ func (s *Session) handleUpdateFile(payload json.RawMessage) {
 	var data UpdateFile
 	if err := json.Unmarshal(payload, &data); err != nil {
 		fmt.Println("Error unmarshalling update file payload:", err)
-		s.sendResponse(false, "Error unmarshalling payload: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Error unmarshalling payload: "+err.Error(), nil)
 		return
 	}
 
 	decoded, err := base64.StdEncoding.DecodeString(data.Content)
 	if err != nil {
 		fmt.Println("Error decoding content:", err)
-		s.sendResponse(false, "Error decoding content: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Error decoding content: "+err.Error(), nil)
+		return
+	}
+
+	fsys, err := s.workspaceFS(ctx)
+	if err != nil {
+		s.sendResponse(ctx, false, err.Error(), nil)
 		return
 	}
 
-	if err := os.WriteFile(data.FilePath, decoded, 0644); err != nil {
+	if err := fsys.WriteFile(ctx, data.FilePath, decoded); err != nil {
 		fmt.Println("Error writing file:", err)
-		s.sendResponse(false, "Error writing file: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Error writing file: "+err.Error(), nil)
 		return
 	}
-	s.sendResponse(true, "File updated successfully", nil)
+	s.sendResponse(ctx, true, "File updated successfully", nil)
 }
 
-func (s *Session) handleDeleteFile(payload json.RawMessage) {
+func (s *Session) handleDeleteFile(ctx context.Context, payload json.RawMessage) {
 	var data struct {
 		FileName string `json:"fileName"`
 		FilePath string `json:"filePath"`
 	}
 	if err := json.Unmarshal(payload, &data); err != nil {
 		fmt.Println("Error unmarshalling delete file payload:", err)
-		s.sendResponse(false, "Error unmarshalling payload: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Error unmarshalling payload: "+err.Error(), nil)
+		return
+	}
+	fsys, err := s.workspaceFS(ctx)
+	if err != nil {
+		s.sendResponse(ctx, false, err.Error(), nil)
 		return
 	}
-	filePath := filepath.Join(data.FilePath, data.FileName)
-	if err := os.Remove(filePath); err != nil {
+
+	if err := fsys.Remove(ctx, path.Join(data.FilePath, data.FileName)); err != nil {
 		fmt.Println("Error deleting file:", err)
-		s.sendResponse(false, "Error deleting file: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Error deleting file: "+err.Error(), nil)
 		return
 	}
-	s.sendResponse(true, "File deleted successfully", nil)
+	s.sendResponse(ctx, true, "File deleted successfully", nil)
 }
 
-func (s *Session) handleCreateFolder(payload json.RawMessage) {
+func (s *Session) handleCreateFolder(ctx context.Context, payload json.RawMessage) {
 	var data struct {
 		FolderName string `json:"folderName"`
 		FolderPath string `json:"folderPath"`
 	}
 	if err := json.Unmarshal(payload, &data); err != nil {
 		fmt.Println("Error unmarshalling create folder payload:", err)
-		s.sendResponse(false, "Error unmarshalling payload: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Error unmarshalling payload: "+err.Error(), nil)
+		return
+	}
+	fsys, err := s.workspaceFS(ctx)
+	if err != nil {
+		s.sendResponse(ctx, false, err.Error(), nil)
 		return
 	}
-	folderPath := filepath.Join(data.FolderPath, data.FolderName)
-	if err := os.MkdirAll(folderPath, 0755); err != nil {
+
+	if err := fsys.MkdirAll(ctx, path.Join(data.FolderPath, data.FolderName)); err != nil {
 		fmt.Println("Error creating folder:", err)
-		s.sendResponse(false, "Error creating folder: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Error creating folder: "+err.Error(), nil)
 		return
 	}
-	s.sendResponse(true, "Folder created successfully", nil)
+	s.sendResponse(ctx, true, "Folder created successfully", nil)
 }
 
-func (s *Session) handleDeleteFolder(payload json.RawMessage) {
+func (s *Session) handleDeleteFolder(ctx context.Context, payload json.RawMessage) {
 	var data struct {
 		FolderPath string `json:"folderPath"`
 	}
 	if err := json.Unmarshal(payload, &data); err != nil {
 		fmt.Println("Error unmarshalling delete folder payload:", err)
-		s.sendResponse(false, "Error unmarshalling payload: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Error unmarshalling payload: "+err.Error(), nil)
 		return
 	}
-	if err := os.RemoveAll(data.FolderPath); err != nil {
+	fsys, err := s.workspaceFS(ctx)
+	if err != nil {
+		s.sendResponse(ctx, false, err.Error(), nil)
+		return
+	}
+
+	if err := fsys.RemoveAll(ctx, data.FolderPath); err != nil {
 		fmt.Println("Error deleting folder:", err)
-		s.sendResponse(false, "Error deleting folder: "+err.Error(), nil)
+		s.sendResponse(ctx, false, "Error deleting folder: "+err.Error(), nil)
+		return
+	}
+	s.sendResponse(ctx, true, "Folder deleted successfully", nil)
+}
+
+func (s *Session) handleRenamePath(ctx context.Context, payload json.RawMessage) {
+	var data struct {
+		From string `json:"from" validate:"required"`
+		To   string `json:"to" validate:"required"`
+	}
+	if err := json.Unmarshal(payload, &data); err != nil {
+		fmt.Println("Error unmarshalling rename payload:", err)
+		s.sendResponse(ctx, false, "Error unmarshalling payload: "+err.Error(), nil)
+		return
+	}
+	if err := validate.Struct(data); err != nil {
+		s.sendResponse(ctx, false, "Validation error: "+err.Error(), nil)
+		return
+	}
+	fsys, err := s.workspaceFS(ctx)
+	if err != nil {
+		s.sendResponse(ctx, false, err.Error(), nil)
 		return
 	}
-	s.sendResponse(true, "Folder deleted successfully", nil)
+
+	if err := fsys.Rename(ctx, data.From, data.To); err != nil {
+		fmt.Println("Error renaming:", err)
+		s.sendResponse(ctx, false, "Error renaming: "+err.Error(), nil)
+		return
+	}
+	s.sendResponse(ctx, true, "Path renamed successfully", nil)
 }
 
-func (s *Session) handleRequestTerminal(payload json.RawMessage) {
+func (s *Session) handleRequestTerminal(ctx context.Context, payload json.RawMessage) {
 	var data struct {
 		Instruction string `json:"instruction"`
 	}
 	json.Unmarshal(payload, &data)
 
-	if s.K8sClient == nil {
-		s.sendResponse(false, "K8s client not initialized", nil)
+	if err := s.cluster(); err != nil {
+		s.sendResponse(ctx, false, err.Error(), nil)
+		return
+	}
+	id := s.current()
+	if id == "" {
+		s.sendResponse(ctx, false, "No workspace open", nil)
 		return
 	}
 
-	ctx := context.Background()
-	podName, err := s.K8sClient.WaitForPodByLabel(ctx, namespace, fmt.Sprintf("workspace=%s", s.WorkspaceID), 1*time.Second)
-	if err != nil {
-		s.sendResponse(false, "Error finding pod", nil)
+	status, ok := s.server.Status.Get(id)
+	if !ok || !status.Ready {
+		s.sendResponse(ctx, false, "Error finding pod", nil)
 		return
 	}
 
-	wsWriter := &WSWriter{Conn: s.Conn}
-	cmd := []string{"bin/bash", "-c", data.Instruction}
-	s.K8sClient.ExecToPod(ctx, namespace, podName, "shell", cmd, nil, wsWriter, wsWriter, false)
+	//output streams as terminal:output events, then the response says how the command ended:
+	wsWriter := &WSWriter{Session: s, ctx: ctx}
+	cmd := []string{"/bin/bash", "-c", data.Instruction}
+	if err := s.K8sClient.ExecToPod(ctx, namespace, status.Pod, "shell", cmd, nil, wsWriter, wsWriter, false); err != nil {
+		fmt.Println("Error running instruction:", err)
+		s.sendResponse(ctx, false, "Error running instruction: "+err.Error(), nil)
+		return
+	}
+	s.sendResponse(ctx, true, "Instruction finished", nil)
 }
 
-func (s *Session) handleGetTree(payload json.RawMessage) {
+func (s *Session) handleGetTree(ctx context.Context, payload json.RawMessage) {
 	var data struct {
 		WorkspaceId string `json:"workspaceId"`
 	}
@@ -379,21 +1084,40 @@ func (s *Session) handleGetTree(payload json.RawMessage) {
 
 	targetId := data.WorkspaceId
 	if targetId == "" {
-		targetId = s.WorkspaceID
+		targetId = s.current()
 	}
 	if targetId == "" {
-		s.sendResponse(false, "WorkspaceId not found", nil)
+		s.sendResponse(ctx, false, "WorkspaceId not found", nil)
 		return
 	}
 
-	if s.WorkspaceID == "" {
-		s.WorkspaceID = targetId
+	record, err := s.server.Store.Get(targetId)
+	if err != nil || record.Owner != s.User.UserID {
+		s.sendResponse(ctx, false, "WorkspaceId not found", nil)
+		return
 	}
 
-	path := filepath.Join(os.Getenv("CACHE_DIR"), targetId)
-	tree, err := generateTree(path, targetId)
+	fsys, err := s.filesFor(ctx, record)
 	if err != nil {
-		s.sendResponse(false, "Error generating tree", nil)
+		s.sendResponse(ctx, false, err.Error(), nil)
+		return
+	}
+
+	//a reconnecting client picks its workspace back up from the store:
+	s.mu.Lock()
+	if s.WorkspaceID == "" {
+		s.WorkspaceID = record.ID
+		s.ProjectType = record.ProjectType
+	}
+	startWatch := targetId == s.WorkspaceID && s.stopWatch == nil
+	s.mu.Unlock()
+	if startWatch {
+		s.watchFiles(targetId, fsys)
+		s.watchStatus(targetId)
+	}
+	tree, err := generateTree(ctx, fsys, "/", targetId)
+	if err != nil {
+		s.sendResponse(ctx, false, "Error generating tree", nil)
 		return
 	}
 
@@ -401,95 +1125,78 @@ func (s *Session) handleGetTree(payload json.RawMessage) {
 		Tree FileNode `json:"tree"`
 	}
 	resp, _ := json.Marshal(getTreeResponse{Tree: tree})
-	s.sendResponse(true, "Succesfully generated tree", resp)
+	s.sendResponse(ctx, true, "Succesfully generated tree", resp)
 }
 
-func generateTree(path string, name string) (FileNode, error) {
-	entries, err := os.ReadDir(path)
+// generateTree walks a workspace folder given as a client path; paths in the tree are workspace relative
+func generateTree(ctx context.Context, fsys workspace.FileService, dir string, name string) (FileNode, error) {
+	entries, err := fsys.ReadDir(ctx, dir)
 	if err != nil {
 		return FileNode{}, err
 	}
 	var Tree FileNode
 	Tree.Name = name
 	Tree.Type = "folder"
-	Tree.Path = path
+	Tree.Path = dir
 
 	for _, entry := range entries {
 		//ignore node_modules and .git:
-		if entry.Name() == "node_modules" || entry.Name() == ".git" {
+		if entry.Name == "node_modules" || entry.Name == ".git" {
 			continue
 		}
 
 		var child FileNode
-		if entry.IsDir() {
-			child, _ = generateTree(filepath.Join(path, entry.Name()), entry.Name())
+		if entry.IsDir {
+			child, _ = generateTree(ctx, fsys, path.Join(dir, entry.Name), entry.Name)
 		} else {
-			child.Name = entry.Name()
+			child.Name = entry.Name
 			child.Type = "file"
-			child.Path = filepath.Join(path, entry.Name())
+			child.Path = path.Join(dir, entry.Name)
 		}
 		Tree.Children = append(Tree.Children, child)
 	}
 	return Tree, nil
 }
 
-func (s *Session) handleStopWorkspace(payload json.RawMessage) {
+func (s *Session) handleStopWorkspace(ctx context.Context, payload json.RawMessage) {
 	var data struct {
 		WorkspaceId string `json:"workspaceId"`
 	}
 	json.Unmarshal(payload, &data)
 
 	// Determine ID
-	targetId := s.WorkspaceID
+	targetId := s.current()
 	if targetId == "" {
 		targetId = data.WorkspaceId
 	}
 
 	if targetId == "" {
-		s.sendResponse(false, "Workspace ID missing", nil)
+		s.sendResponse(ctx, false, "Workspace ID missing", nil)
+		return
+	}
+
+	if !s.owns(targetId) {
+		s.sendResponse(ctx, false, "Workspace not found", nil)
 		return
 	}
 
 	//cleanup function:
 	err := s.cleanup(targetId)
-	if err != nil{
-		fmt.Println("Error Cleaning Up: ",err)
-		s.sendResponse(false, "Error Cleaning Up:"+err.Error(),nil)
+	if err != nil {
+		fmt.Println("Error Cleaning Up: ", err)
+		s.sendResponse(ctx, false, "Error Cleaning Up:"+err.Error(), nil)
+		return
 	}
 
 	fmt.Printf("Workspace %s stopped and cleaned up.\n", targetId)
-	s.sendResponse(true, "Workspace stopped successfully", nil)
+	s.sendResponse(ctx, true, "Workspace stopped successfully", nil)
 }
 
-func (s *Session)cleanup(targetId string) error{
-	ctx := context.Background()
-	resourceName := fmt.Sprintf("shell-%s", targetId)
-
-	//delete resources:
-	if s.ProjectType == "react" || s.ProjectType == "nodejs" {
-		if err := s.K8sClient.DeleteResource(ctx, "Ingress", resourceName, namespace); err != nil {
-			fmt.Println("Error deleting ingress", err)
-			return err
-		}
-
-		if err := s.K8sClient.DeleteResource(ctx, "Service", resourceName, namespace); err != nil {
-			fmt.Println("Error deleting ingress", err)
-			return err
-		}
-	}
-
-	if err := s.K8sClient.DeleteResource(ctx, "Pod", resourceName, namespace); err != nil {
-		fmt.Println("Error deleting pod:", err)
+// cleanup stops a workspace for good once the user is done with it, see Server.StopWorkspace
+func (s *Session) cleanup(targetId string) error {
+	if err := s.cluster(); err != nil {
 		return err
-
 	}
-
-	//delete Local Cache
-	cacheDir := filepath.Join(os.Getenv("CACHE_DIR"), targetId)
-	if err := os.RemoveAll(cacheDir); err != nil {
-		fmt.Println("Error deleting cache:", err)
-		return err
-	}
-
-	return nil
+	//deletion continues when the request or the connection goes away:
+	return s.server.StopWorkspace(context.Background(), targetId)
 }
@@ -0,0 +1,577 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mudit06mah/CloudIde/auth"
+	"github.com/mudit06mah/CloudIde/catalog"
+	"github.com/mudit06mah/CloudIde/k8s"
+	"github.com/mudit06mah/CloudIde/templates"
+	"github.com/mudit06mah/CloudIde/workspace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// frame is anything the server sends: responses, and events when Event is set
+type frame struct {
+	Event string `json:"event"`
+	Response
+}
+
+// testClient is a v2 websocket session with a server; frames are read in the background
+type testClient struct {
+	t      *testing.T
+	conn   *websocket.Conn
+	frames chan frame
+}
+
+// dialSession opens a session with srv for the user, under a token signed by srv.Auth
+func dialSession(t *testing.T, srv *Server, user string) *testClient {
+	t.Helper()
+	token, err := srv.Auth.(*auth.HMACAuthenticator).Sign(user, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(srv.wsHandler))
+	t.Cleanup(server.Close)
+
+	dialer := websocket.Dialer{Subprotocols: []string{ProtocolV2}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestClient(t, conn)
+}
+
+// newTestClient reads conn's frames in the background until it closes
+func newTestClient(t *testing.T, conn *websocket.Conn) *testClient {
+	t.Cleanup(func() { conn.Close() })
+
+	c := &testClient{t: t, conn: conn, frames: make(chan frame, 256)}
+	go func() {
+		defer close(c.frames)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var f frame
+			if json.Unmarshal(msg, &f) == nil {
+				c.frames <- f
+			}
+		}
+	}()
+	return c
+}
+
+// send writes a request; only the test goroutine may call it
+func (c *testClient) send(id string, msgType string, payload any) {
+	c.t.Helper()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	msg, _ := json.Marshal(Message{ID: id, Type: msgType, Payload: data})
+	if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// reply waits for the response to request id, skipping events and other responses
+func (c *testClient) reply(id string) Response {
+	c.t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case f, ok := <-c.frames:
+			if !ok {
+				c.t.Fatalf("connection closed before the reply to %s", id)
+			}
+			if f.Event == "" && f.ID == id {
+				return f.Response
+			}
+		case <-timeout:
+			c.t.Fatalf("no reply to %s", id)
+		}
+	}
+}
+
+// replies waits for the responses to the requests ids, in whatever order they come
+func (c *testClient) replies(ids ...string) map[string]Response {
+	c.t.Helper()
+	got := make(map[string]Response)
+	for _, id := range ids {
+		got[id] = Response{}
+	}
+	timeout := time.After(10 * time.Second)
+	for waiting := len(ids); waiting > 0; {
+		select {
+		case f, ok := <-c.frames:
+			if !ok {
+				c.t.Fatalf("connection closed before the replies to %v", ids)
+			}
+			if r, want := got[f.ID]; want && f.Event == "" && r.ID == "" {
+				got[f.ID] = f.Response
+				waiting--
+			}
+		case <-timeout:
+			c.t.Fatalf("no replies to %v", ids)
+		}
+	}
+	return got
+}
+
+func TestCreateWorkspaceId(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id, err := createWorkspaceId(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != 10 || strings.Trim(id, "abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+			t.Fatalf("createWorkspaceId(10) = %q, want 10 lowercase letters and digits", id)
+		}
+		if seen[id] {
+			t.Fatalf("createWorkspaceId(10) repeated %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+// newProvisioningServer returns a server over fake clusters that provisions hostpath
+// workspaces from source
+func newProvisioningServer(t *testing.T, source templates.Source) (*Server, *fake.Clientset) {
+	t.Helper()
+	t.Setenv("CACHE_DIR", t.TempDir())
+	t.Setenv("SNAPSHOTS_ENABLED", "false")
+
+	projectCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kube := fake.NewSimpleClientset()
+	status := k8s.NewStatusController(kube)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := status.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	authenticator := auth.NewHMACAuthenticator([]byte("test secret"))
+	authenticator.DefaultPlans = []string{projectCatalog.DefaultProfile()}
+	return &Server{
+		Auth:      authenticator,
+		Store:     workspace.NewMemoryStore(),
+		Activity:  workspace.NewActivity(),
+		Templates: source,
+		Catalog:   projectCatalog,
+		Storage:   workspace.Storage{Backend: workspace.StorageHostPath},
+		K8s:       newTestCluster(t, &apiServer{}),
+		Status:    status,
+	}, kube
+}
+
+// startPods plays the kubelet: it starts a ready pod for every workspace recorded in the store
+func startPods(t *testing.T, srv *Server, kube *fake.Clientset) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		started := make(map[string]bool)
+		for ctx.Err() == nil {
+			records, _ := srv.Store.List()
+			for _, rec := range records {
+				if started[rec.ID] {
+					continue
+				}
+				started[rec.ID] = true
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "shell-" + rec.ID, Namespace: k8s.Namespace, Labels: map[string]string{k8s.LabelWorkspace: rec.ID}},
+					Status: corev1.PodStatus{Phase: corev1.PodRunning, Conditions: []corev1.PodCondition{
+						{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+						{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+					}},
+				}
+				kube.CoreV1().Pods(k8s.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+}
+
+// TestCreateProjectFromTemplate provisions a workspace end to end with an in-memory template
+func TestCreateProjectFromTemplate(t *testing.T) {
+	source := &templates.MemorySource{Templates: map[string]map[string]string{
+		"python": {"main.py": "print('hello')\n", "lib/util.py": "x = 1\n"},
+	}}
+	srv, kube := newProvisioningServer(t, source)
+	startPods(t, srv, kube)
+
+	client := dialSession(t, srv, "user-1")
+	client.send("create-1", "initProject", map[string]string{"projectType": "python"})
+	resp := client.reply("create-1")
+	if !resp.Success {
+		t.Fatalf("initProject failed: %s", resp.Message)
+	}
+
+	var project ProjectPayload
+	if err := json.Unmarshal(resp.Payload, &project); err != nil {
+		t.Fatal(err)
+	}
+	if got := source.Fetched(); !reflect.DeepEqual(got, []string{"python"}) {
+		t.Errorf("templates fetched %v, want [python]", got)
+	}
+	data, err := os.ReadFile(filepath.Join(os.Getenv("CACHE_DIR"), project.WorkspaceId, "lib", "util.py"))
+	if err != nil || string(data) != "x = 1\n" {
+		t.Errorf("workspace file lib/util.py = %q, %v", data, err)
+	}
+	tree, _ := json.Marshal(project.Tree)
+	for _, name := range []string{"main.py", "util.py"} {
+		if !strings.Contains(string(tree), name) {
+			t.Errorf("file tree %s lacks %s", tree, name)
+		}
+	}
+	rec, err := srv.Store.Get(project.WorkspaceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Owner != "user-1" || rec.State != workspace.StateRunning || !rec.Started {
+		t.Errorf("record %+v, want a started workspace of user-1", rec)
+	}
+}
+
+// TestCreateProjectMissingTemplate checks a template the source can't provide fails the
+// workspace before anything is applied to the cluster
+func TestCreateProjectMissingTemplate(t *testing.T) {
+	srv, _ := newProvisioningServer(t, &templates.MemorySource{})
+	client := dialSession(t, srv, "user-1")
+	client.send("create-1", "initProject", map[string]string{"projectType": "python"})
+	resp := client.reply("create-1")
+	if resp.Success || !strings.Contains(resp.Message, "Error downloading template") {
+		t.Fatalf("initProject = %+v, want a template error", resp)
+	}
+
+	records, _ := srv.Store.List()
+	if len(records) != 1 || records[0].State != workspace.StateFailed || len(records[0].Resources) != 0 {
+		t.Errorf("records %+v, want one failed workspace without resources", records)
+	}
+}
+
+// blockingFS is a workspace whose every call blocks until the test releases it, or its
+// request is cancelled
+type blockingFS struct {
+	// started gets "<op> <path>" as calls begin; cancelled as their context ends first
+	started   chan string
+	cancelled chan string
+	// release lets one call return, every call once closed
+	release chan struct{}
+}
+
+func newBlockingFS() *blockingFS {
+	return &blockingFS{
+		started:   make(chan string, 64),
+		cancelled: make(chan string, 64),
+		release:   make(chan struct{}),
+	}
+}
+
+func (f *blockingFS) block(ctx context.Context, op string, clientPath string) error {
+	call := op + " " + clientPath
+	f.started <- call
+	select {
+	case <-f.release:
+		return nil
+	case <-ctx.Done():
+		f.cancelled <- call
+		return ctx.Err()
+	}
+}
+
+func (f *blockingFS) Create(ctx context.Context, clientPath string) error {
+	return f.block(ctx, "Create", clientPath)
+}
+
+func (f *blockingFS) ReadFile(ctx context.Context, clientPath string) ([]byte, error) {
+	return nil, f.block(ctx, "ReadFile", clientPath)
+}
+
+func (f *blockingFS) WriteFile(ctx context.Context, clientPath string, data []byte) error {
+	return f.block(ctx, "WriteFile", clientPath)
+}
+
+func (f *blockingFS) Remove(ctx context.Context, clientPath string) error {
+	return f.block(ctx, "Remove", clientPath)
+}
+
+func (f *blockingFS) MkdirAll(ctx context.Context, clientPath string) error {
+	return f.block(ctx, "MkdirAll", clientPath)
+}
+
+func (f *blockingFS) RemoveAll(ctx context.Context, clientPath string) error {
+	return f.block(ctx, "RemoveAll", clientPath)
+}
+
+func (f *blockingFS) ReadDir(ctx context.Context, clientPath string) ([]workspace.Entry, error) {
+	return nil, f.block(ctx, "ReadDir", clientPath)
+}
+
+func (f *blockingFS) Rename(ctx context.Context, from string, to string) error {
+	return f.block(ctx, "Rename", from)
+}
+
+// next returns the next call to arrive on ch
+func next(t *testing.T, ch chan string) string {
+	t.Helper()
+	select {
+	case call := <-ch:
+		return call
+	case <-time.After(5 * time.Second):
+		t.Fatal("no call arrived")
+		return ""
+	}
+}
+
+// idle checks no call arrives on ch for a while
+func idle(t *testing.T, ch chan string) {
+	t.Helper()
+	select {
+	case call := <-ch:
+		t.Fatalf("unexpected call %s", call)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// dialFS opens a session with srv whose workspace is ws1, its files fsys; a nil srv has no
+// cluster
+func dialFS(t *testing.T, srv *Server, fsys workspace.FileService) *testClient {
+	t.Helper()
+	if srv == nil {
+		srv = &Server{Store: workspace.NewMemoryStore(), Activity: workspace.NewActivity()}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{Subprotocols: []string{ProtocolV2}}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn := NewConn(ws)
+		defer conn.Close()
+		session := NewSession(conn, srv, &auth.Identity{UserID: "user-1"})
+		session.WorkspaceID, session.FS = "ws1", fsys
+		session.serve()
+	}))
+	t.Cleanup(server.Close)
+
+	dialer := websocket.Dialer{Subprotocols: []string{ProtocolV2}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestClient(t, conn)
+}
+
+// TestHandleMessageInFlightLimit checks a session runs MaxInFlight messages at once, rejects
+// more, and takes new ones again as they finish. Run it with -race.
+func TestHandleMessageInFlightLimit(t *testing.T) {
+	fsys := newBlockingFS()
+	defer close(fsys.release)
+	client := dialFS(t, nil, fsys)
+
+	for i := 0; i < MaxInFlight; i++ {
+		client.send(fmt.Sprintf("get-%d", i), "getFile", map[string]string{"filePath": fmt.Sprintf("f%d", i)})
+	}
+	for i := 0; i < MaxInFlight; i++ {
+		next(t, fsys.started)
+	}
+
+	client.send("over", "getFile", map[string]string{"filePath": "over"})
+	if got := client.reply("over"); got.Success || !strings.Contains(got.Message, "Too many requests") {
+		t.Fatalf("reply = %+v, want the request over the limit rejected", got)
+	}
+	idle(t, fsys.started)
+
+	//finishing one frees a slot:
+	fsys.release <- struct{}{}
+	for {
+		f := <-client.frames
+		if f.Event == "" && strings.HasPrefix(f.ID, "get-") {
+			if !f.Success {
+				t.Fatalf("reply = %+v, want the released request done", f.Response)
+			}
+			break
+		}
+	}
+	client.send("after", "getFile", map[string]string{"filePath": "after"})
+	if call := next(t, fsys.started); call != "ReadFile after" {
+		t.Errorf("started %s, want ReadFile after", call)
+	}
+}
+
+// TestHandleMessageCancel checks a cancel message aborts the request with its id, and only it
+func TestHandleMessageCancel(t *testing.T) {
+	fsys := newBlockingFS()
+	defer close(fsys.release)
+	client := dialFS(t, nil, fsys)
+
+	client.send("slow", "getFile", map[string]string{"filePath": "slow"})
+	client.send("other", "getFile", map[string]string{"filePath": "other"})
+	next(t, fsys.started)
+	next(t, fsys.started)
+
+	client.send("cancel-1", "cancel", map[string]string{"id": "slow"})
+	got := client.replies("cancel-1", "slow")
+	if !got["cancel-1"].Success {
+		t.Fatalf("cancel reply = %+v, want success", got["cancel-1"])
+	}
+	if got["slow"].Success {
+		t.Errorf("reply = %+v, want the cancelled request failed", got["slow"])
+	}
+	if call := next(t, fsys.cancelled); call != "ReadFile slow" {
+		t.Errorf("cancelled %s, want ReadFile slow", call)
+	}
+	idle(t, fsys.cancelled)
+
+	//the id is free again once its request is done:
+	client.send("cancel-2", "cancel", map[string]string{"id": "slow"})
+	if got := client.reply("cancel-2"); got.Success {
+		t.Errorf("cancel reply = %+v, want no request in progress", got)
+	}
+}
+
+// TestHandleMessageDisconnect checks closing the socket cancels every message still running
+func TestHandleMessageDisconnect(t *testing.T) {
+	fsys := newBlockingFS()
+	defer close(fsys.release)
+	client := dialFS(t, nil, fsys)
+
+	client.send("get-1", "getFile", map[string]string{"filePath": "a"})
+	client.send("", "getFile", map[string]string{"filePath": "b"})
+	next(t, fsys.started)
+	next(t, fsys.started)
+
+	client.conn.Close()
+	got := map[string]bool{next(t, fsys.cancelled): true, next(t, fsys.cancelled): true}
+	if !got["ReadFile a"] || !got["ReadFile b"] {
+		t.Errorf("cancelled %v, want both requests", got)
+	}
+}
+
+// TestHandleMessageFileOrder checks file changes run one at a time in the order they were
+// sent, reads don't wait for them, and a change cancelled while queued doesn't let the ones
+// after it overtake the change running
+func TestHandleMessageFileOrder(t *testing.T) {
+	fsys := newBlockingFS()
+	defer close(fsys.release)
+	client := dialFS(t, nil, fsys)
+
+	for _, name := range []string{"a", "b", "c"} {
+		client.send("update-"+name, "updateFile", map[string]string{"filePath": name, "content": ""})
+	}
+	if call := next(t, fsys.started); call != "WriteFile a" {
+		t.Fatalf("started %s, want WriteFile a", call)
+	}
+	idle(t, fsys.started)
+
+	client.send("get", "getFile", map[string]string{"filePath": "r"})
+	if call := next(t, fsys.started); call != "ReadFile r" {
+		t.Fatalf("started %s, want the read to run alongside the change", call)
+	}
+
+	client.send("cancel", "cancel", map[string]string{"id": "update-b"})
+	got := client.replies("cancel", "update-b")
+	if !got["cancel"].Success {
+		t.Fatalf("cancel reply = %+v, want success", got["cancel"])
+	}
+	if got["update-b"].Success {
+		t.Fatalf("reply = %+v, want the queued change cancelled", got["update-b"])
+	}
+	idle(t, fsys.started)
+
+	fsys.release <- struct{}{}
+	fsys.release <- struct{}{}
+	if call := next(t, fsys.started); call != "WriteFile c" {
+		t.Fatalf("started %s, want WriteFile c", call)
+	}
+	fsys.release <- struct{}{}
+	for id, reply := range client.replies("update-a", "get", "update-c") {
+		if !reply.Success {
+			t.Errorf("reply to %s = %+v, want success", id, reply)
+		}
+	}
+}
+
+// execServer refuses pod exec requests, recording the commands asked for
+type execServer struct {
+	commands chan []string
+}
+
+func (e *execServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/exec") {
+		e.commands <- r.URL.Query()["command"]
+	}
+	http.Error(w, "exec not allowed", http.StatusForbidden)
+}
+
+// TestRequestTerminalExecFails checks an instruction that can't run is answered with the
+// error, once, and that it is run with /bin/bash
+func TestRequestTerminalExecFails(t *testing.T) {
+	kube := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "shell-ws1-0", Namespace: k8s.Namespace, Labels: map[string]string{k8s.LabelWorkspace: "ws1"}},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+			},
+		},
+	})
+	status := k8s.NewStatusController(kube)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := status.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := status.WaitReady(ctx, "ws1", 5*time.Second, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	api := &execServer{commands: make(chan []string, 1)}
+	srv := &Server{
+		Store:    workspace.NewMemoryStore(),
+		Activity: workspace.NewActivity(),
+		K8s:      newTestCluster(t, api),
+		Status:   status,
+	}
+	client := dialFS(t, srv, newBlockingFS())
+
+	client.send("run", "requestTerminal", map[string]string{"instruction": "ls"})
+	if got := client.reply("run"); got.Success || !strings.Contains(got.Message, "Error running instruction") {
+		t.Errorf("reply = %+v, want the exec error", got)
+	}
+	if got, want := <-api.commands, []string{"/bin/bash", "-c", "ls"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("command = %v, want %v", got, want)
+	}
+
+	//nothing else answers the request:
+	timeout := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case f := <-client.frames:
+			if f.Event == "" && f.ID == "run" {
+				t.Fatalf("second reply %+v", f.Response)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mudit06mah/CloudIde/k8s"
+	"github.com/mudit06mah/CloudIde/workspace"
+)
+
+// Provisioning stages, sent in order as "Workspace progress" messages while a workspace starts
+const (
+	StageTemplateDownloading = "template:downloading"
+	StageManifestsApplied    = "manifests:applied"
+	StagePodPending          = "pod:pending"
+	StagePodScheduled        = "pod:scheduled"
+	StageImagePulling        = "image:pulling"
+	StagePodReady            = "pod:ready"
+	// StageFailed ends provisioning; its message says why
+	StageFailed = "failed"
+)
+
+// podStages orders the stages derived from the pod, so progress never goes backwards
+var podStages = map[string]int{
+	StagePodPending:   0,
+	StagePodScheduled: 1,
+	StageImagePulling: 2,
+	StagePodReady:     3,
+}
+
+// podReadyTimeout bounds how long provisioning waits for the workspace pod
+const podReadyTimeout = 300 * time.Second
+
+type progressEvent struct {
+	WorkspaceID string `json:"workspaceId"`
+	Stage       string `json:"stage"`
+	Message     string `json:"message,omitempty"`
+}
+
+// sendProgress reports a stage as an event of the provisioning request under ctx
+func (s *Session) sendProgress(ctx context.Context, workspaceId string, stage string, message string) {
+	payload, err := json.Marshal(progressEvent{WorkspaceID: workspaceId, Stage: stage, Message: message})
+	if err != nil {
+		return
+	}
+	s.sendEvent(ctx, "Workspace progress", payload)
+}
+
+// failProvisioning marks the workspace failed, so the reaper cleans it up, reports a failed
+// stage as progress and answers the request with the error
+func (s *Session) failProvisioning(ctx context.Context, record *workspace.Record, message string) {
+	record.State = workspace.StateFailed
+	s.updateRecord(record)
+	s.sendProgress(ctx, record.ID, StageFailed, message)
+	s.sendResponse(ctx, false, message, nil)
+}
+
+// waitForPod waits for the workspace pod to become ready, reporting scheduling, image pulls
+// and readiness as progress. Errors carry the pod's last reason and event.
+func (s *Session) waitForPod(ctx context.Context, workspaceId string) (k8s.PodStatus, error) {
+	last, lastMessage := -1, ""
+	return s.server.Status.WaitReady(ctx, workspaceId, podReadyTimeout, func(status k8s.PodStatus) {
+		stage, message := podStage(status)
+		index := podStages[stage]
+		if index < last || (index == last && message == lastMessage) {
+			return
+		}
+		last, lastMessage = index, message
+		s.sendProgress(ctx, workspaceId, stage, message)
+	})
+}
+
+// podStage maps a pod status to a provisioning stage and the Kubernetes text explaining it
+func podStage(status k8s.PodStatus) (string, string) {
+	switch {
+	case status.Ready:
+		return StagePodReady, ""
+	case !status.Scheduled:
+		return StagePodPending, status.Message
+	case status.Reason == "ErrImagePull" || status.Reason == "ImagePullBackOff":
+		if status.Event != "" {
+			return StageImagePulling, fmt.Sprintf("%s: %s", status.Reason, status.Event)
+		}
+		return StageImagePulling, fmt.Sprintf("%s: %s", status.Reason, status.Message)
+	case status.EventReason == "Pulling" || status.EventReason == "Pulled":
+		return StageImagePulling, status.Event
+	case status.EventReason == "Scheduled":
+		return StagePodScheduled, status.Event
+	default:
+		return StagePodScheduled, ""
+	}
+}
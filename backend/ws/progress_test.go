@@ -0,0 +1,35 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/mudit06mah/CloudIde/k8s"
+)
+
+// TestPodStageImagePulls checks failed pulls the kubelet is retrying are reported as image
+// pulling progress carrying Kubernetes' explanation, rather than as failures
+func TestPodStageImagePulls(t *testing.T) {
+	tests := []struct {
+		status  k8s.PodStatus
+		message string
+	}{
+		{
+			status:  k8s.PodStatus{Scheduled: true, Reason: "ErrImagePull", Message: "rpc error", EventReason: "Failed", Event: "Failed to pull image: registry unavailable"},
+			message: "ErrImagePull: Failed to pull image: registry unavailable",
+		},
+		{
+			status:  k8s.PodStatus{Scheduled: true, Reason: "ImagePullBackOff", Message: "Back-off pulling image"},
+			message: "ImagePullBackOff: Back-off pulling image",
+		},
+		{
+			status:  k8s.PodStatus{Scheduled: true, Reason: "ContainerCreating", EventReason: "Pulling", Event: "Pulling image python:3.12"},
+			message: "Pulling image python:3.12",
+		},
+	}
+	for _, tt := range tests {
+		stage, message := podStage(tt.status)
+		if stage != StageImagePulling || message != tt.message {
+			t.Errorf("podStage(%s) = %s %q, want %s %q", tt.status.Reason, stage, message, StageImagePulling, tt.message)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// Protocol versions, negotiated through the Sec-WebSocket-Protocol header. A client that
+// asks for none speaks v1, where server-pushed data arrives as responses.
+const (
+	ProtocolV1 = "cloudide.v1"
+	// ProtocolV2 echoes request ids and types on responses and pushes data as event frames
+	ProtocolV2 = "cloudide.v2"
+)
+
+// protocols are the supported versions, newest (preferred) first
+var protocols = []string{ProtocolV2, ProtocolV1}
+
+// Event is a frame the server pushes without being asked, e.g. terminal output or file
+// changes. ID is set when the event belongs to a request still in progress.
+type Event struct {
+	Event   string          `json:"event"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// negotiateProtocol reports whether the client offers a supported version, or none at all
+func negotiateProtocol(r *http.Request) bool {
+	offered := websocket.Subprotocols(r)
+	if len(offered) == 0 {
+		return true
+	}
+	for _, protocol := range offered {
+		for _, supported := range protocols {
+			if protocol == supported {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type requestKey struct{}
+
+// request is the message a handler is answering
+type request struct {
+	ID   string
+	Type string
+}
+
+func withRequest(ctx context.Context, msg Message) context.Context {
+	return context.WithValue(ctx, requestKey{}, request{ID: msg.ID, Type: msg.Type})
+}
+
+// requestFrom returns the request handled under ctx; empty for pushed data
+func requestFrom(ctx context.Context) request {
+	req, _ := ctx.Value(requestKey{}).(request)
+	return req
+}
@@ -1,83 +1,157 @@
 package ws
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 
 	"github.com/gorilla/websocket"
+	"github.com/mudit06mah/CloudIde/auth"
+	"github.com/mudit06mah/CloudIde/catalog"
 	"github.com/mudit06mah/CloudIde/k8s"
+	"github.com/mudit06mah/CloudIde/templates"
+	"github.com/mudit06mah/CloudIde/workspace"
 )
 
+// Server holds the dependencies shared by every connection
+type Server struct {
+	Auth      auth.Authenticator
+	Store     workspace.Store
+	Activity  *workspace.Activity
+	Templates templates.Source
+	Catalog   *catalog.Catalog
+	// Storage is used for new workspaces; existing ones keep the backend on their record
+	Storage workspace.Storage
+	// K8s is shared by every session; nil when no cluster was reachable at startup
+	K8s *k8s.Client
+	// Status tracks workspace pods; set together with K8s
+	Status *k8s.StatusController
+}
+
+var errNoCluster = errors.New("no Kubernetes cluster available")
+
 // StartWebSocketServer initializes the router
-func StartWebSocketServer() error {
+func StartWebSocketServer(srv *Server) error {
 	wsPort := os.Getenv("WS_PORT")
 	if wsPort == "" {
 		wsPort = "8080"
 	}
 
-	http.HandleFunc("/ws", wsHandler)
+	if strings.Contains(os.Getenv("ALLOWED_ORIGINS"), "*") {
+		log.Println("WARNING: websocket connections accepted from any origin (ALLOWED_ORIGINS=*)")
+	}
+
+	http.HandleFunc("/ws", srv.wsHandler)
 	log.Println("WebSocket server started on port:", wsPort)
 	return http.ListenAndServe(":"+wsPort, nil)
 }
 
-func wsHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := srv.Auth.Authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
 	query := r.URL.Query()
 	connType := query.Get("type")
 
 	switch connType {
 	case "terminal":
-		podName := query.Get("pod")
-		if podName == "" {
-			http.Error(w, "Query missing pod name", http.StatusBadRequest)
-			return
-		}
-
 		workspaceId := query.Get("workspaceId")
 		if workspaceId == "" {
 			http.Error(w, "Query missing workspaceId", http.StatusBadRequest)
 			return
 		}
 
-		k8sClient, err := k8s.NewK8sClient(workspaceId)
-		if err != nil {
-			http.Error(w, "Failed to create K8s client: "+err.Error(), http.StatusInternalServerError)
+		record, err := srv.Store.Get(workspaceId)
+		if err != nil || record.Owner != user.UserID {
+			http.Error(w, "Workspace not found", http.StatusForbidden)
 			return
 		}
 
-		HandleTerminal(w, r, k8sClient.Clientset, k8sClient.Config, podName)
+		//the pod always comes from the workspace record, never trusted from the client:
+		podName := record.PodName
+		if pod := query.Get("pod"); pod != "" && pod != podName {
+			http.Error(w, "Pod does not belong to workspace", http.StatusForbidden)
+			return
+		}
+
+		if srv.K8s == nil {
+			http.Error(w, errNoCluster.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		HandleTerminal(w, r, srv.K8s.Clientset, srv.K8s.Config, podName, func() {
+			srv.Activity.Touch(workspaceId)
+		})
 		return
 
 	default:
-		handleWebSocket(w, r)
+		srv.handleWebSocket(w, r, user)
 	}
 }
 
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) handleWebSocket(w http.ResponseWriter, r *http.Request, user *auth.Identity) {
+	if !negotiateProtocol(r) {
+		http.Error(w, "Unsupported protocol, expected one of: "+strings.Join(protocols, ", "), http.StatusBadRequest)
+		return
+	}
 	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+		CheckOrigin:  checkOrigin,
+		Subprotocols: protocols,
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Failed to upgrade connection:", err)
 		return
 	}
+	conn := NewConn(ws)
 	defer conn.Close()
 
-	session := NewSession(conn)
-	workspaceId := r.URL.Query().Get("workspaceId")
-	defer session.cleanup(workspaceId)
+	//a closed tab leaves the workspace to reattach to: only stopWorkspace stops it, and the
+	//reaper suspends it once idle
+	NewSession(conn, srv, user).serve()
+}
+
+// serve routes the connection's messages to the session until it closes, then cancels the
+// messages still running
+func (s *Session) serve() {
+	defer s.Close()
 
 	for {
-		_, msg, err := conn.ReadMessage()
+		_, msg, err := s.Conn.ReadMessage()
 		if err != nil {
 			// Log disconnection if needed
 			break
 		}
 		// Route message to the specific session instance
-		session.HandleMessage(msg)
+		s.HandleMessage(msg)
+	}
+}
+
+// checkOrigin accepts same-origin requests and requests without an Origin header, which only
+// non-browser clients send. Other origins must be listed in ALLOWED_ORIGINS (comma separated); "*" allows
+// every origin, letting any site a user visits open sessions with their token.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
 	}
-}
\ No newline at end of file
+
+	for _, o := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o == "*" || (o != "" && o == origin) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
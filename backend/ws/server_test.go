@@ -0,0 +1,149 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mudit06mah/CloudIde/auth"
+	"github.com/mudit06mah/CloudIde/k8s"
+	"github.com/mudit06mah/CloudIde/workspace"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		allowed string
+		origin  string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "http://ide.example.com", true},
+		{"", "http://IDE.example.com", true},
+		{"", "http://evil.example.com", false},
+		{"", "http://ide.example.com:8443", false},
+		{"http://localhost:5173", "http://localhost:5173", true},
+		{"http://localhost:5173, http://localhost:3000", "http://localhost:3000", true},
+		{"http://localhost:5173", "http://evil.example.com", false},
+		{"*", "http://evil.example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("ALLOWED_ORIGINS", tt.allowed)
+		r := httptest.NewRequest("GET", "http://ide.example.com/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := checkOrigin(r); got != tt.want {
+			t.Errorf("ALLOWED_ORIGINS=%q, Origin %q: checkOrigin = %v, want %v", tt.allowed, tt.origin, got, tt.want)
+		}
+	}
+}
+
+// TestTerminalOwnership checks the terminal endpoint refuses, before any upgrade, tokens for
+// another user's workspace and pods that aren't the workspace's
+func TestTerminalOwnership(t *testing.T) {
+	authenticator := auth.NewHMACAuthenticator([]byte("test secret"))
+	srv := &Server{Auth: authenticator, Store: workspace.NewMemoryStore(), Activity: workspace.NewActivity()}
+	if err := srv.Store.Put(&workspace.Record{ID: "ws1", Owner: "owner", PodName: "shell-ws1", State: workspace.StateRunning}); err != nil {
+		t.Fatal(err)
+	}
+	ownerToken, _ := authenticator.Sign("owner", time.Hour)
+	otherToken, _ := authenticator.Sign("other", time.Hour)
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  int
+	}{
+		{"no token", url.Values{"type": {"terminal"}, "workspaceId": {"ws1"}}, http.StatusUnauthorized},
+		{"bad token", url.Values{"type": {"terminal"}, "workspaceId": {"ws1"}, "token": {ownerToken + "x"}}, http.StatusUnauthorized},
+		{"wrong owner", url.Values{"type": {"terminal"}, "workspaceId": {"ws1"}, "token": {otherToken}}, http.StatusForbidden},
+		{"unknown workspace", url.Values{"type": {"terminal"}, "workspaceId": {"ws2"}, "token": {ownerToken}}, http.StatusForbidden},
+		{"foreign pod", url.Values{"type": {"terminal"}, "workspaceId": {"ws1"}, "pod": {"shell-ws2"}, "token": {ownerToken}}, http.StatusForbidden},
+		// the owner gets past the ownership checks, this server just has no cluster
+		{"owner", url.Values{"type": {"terminal"}, "workspaceId": {"ws1"}, "token": {ownerToken}}, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			srv.wsHandler(w, httptest.NewRequest("GET", "/ws?"+tt.query.Encode(), nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+// TestDisconnectKeepsWorkspace checks closing the socket of a workspace leaves it running to
+// reattach to, even though the session could stop it
+func TestDisconnectKeepsWorkspace(t *testing.T) {
+	t.Setenv("CACHE_DIR", t.TempDir())
+	t.Setenv("SNAPSHOTS_ENABLED", "false")
+
+	authenticator := auth.NewHMACAuthenticator([]byte("test secret"))
+	statefulSet := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "shell-ws1", Namespace: k8s.Namespace, Labels: map[string]string{k8s.LabelWorkspace: "ws1"},
+	}}
+	srv := &Server{
+		Auth:     authenticator,
+		Store:    workspace.NewMemoryStore(),
+		Activity: workspace.NewActivity(),
+		K8s:      newTestCluster(t, &apiServer{}, statefulSet),
+		Status:   k8s.NewStatusController(fake.NewSimpleClientset()),
+	}
+	record := &workspace.Record{
+		ID:        "ws1",
+		Owner:     "user-1",
+		Storage:   workspace.StorageHostPath,
+		State:     workspace.StateRunning,
+		Started:   true,
+		Resources: []workspace.Resource{{Kind: "StatefulSet", Name: "shell-ws1"}},
+	}
+	if err := srv.Store.Put(record); err != nil {
+		t.Fatal(err)
+	}
+	token, _ := authenticator.Sign("user-1", time.Hour)
+
+	handled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(handled)
+		srv.wsHandler(w, r)
+	}))
+	defer server.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{ProtocolV2}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?workspaceId=ws1&token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	select {
+	case <-handled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("handler still running after the disconnect")
+	}
+
+	got, err := srv.Store.Get("ws1")
+	if err != nil {
+		t.Fatalf("record gone after the disconnect: %v", err)
+	}
+	if got.State != workspace.StateRunning {
+		t.Errorf("state = %s after the disconnect, want %s", got.State, workspace.StateRunning)
+	}
+	list, err := srv.K8s.Dynamic.Resource(appsv1.SchemeGroupVersion.WithResource("statefulsets")).Namespace(k8s.Namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 {
+		t.Errorf("StatefulSet deleted on disconnect")
+	}
+}
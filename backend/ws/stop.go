@@ -0,0 +1,115 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mudit06mah/CloudIde/aws"
+	"github.com/mudit06mah/CloudIde/k8s"
+	"github.com/mudit06mah/CloudIde/workspace"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// StopWorkspace deletes a workspace's Kubernetes objects and local files. With snapshots
+// enabled the files are snapshotted first and the record is kept as stopped, so the
+// workspace can be reopened; otherwise, or when the workspace never ran, the record is
+// deleted too. It is used both when a
+// user stops a workspace and by the reaper.
+func (srv *Server) StopWorkspace(ctx context.Context, workspaceId string) error {
+	if srv.K8s == nil || srv.Status == nil {
+		return errNoCluster
+	}
+
+	record, err := srv.Store.Get(workspaceId)
+	if err != nil {
+		record = nil
+	}
+
+	//a workspace that never ran only holds its template (its pod may never come up either):
+	saveFiles := record != nil && !record.NeverRan() && aws.SnapshotsEnabled()
+
+	//volume backed files only live in the cluster, bring them back before it's deleted:
+	cacheDir := filepath.Join(os.Getenv("CACHE_DIR"), workspaceId)
+	if saveFiles && record.StorageBackend() == workspace.StorageVolume {
+		if err := srv.copyVolumeOut(ctx, record, cacheDir); err != nil {
+			fmt.Println("Error copying workspace files:", err)
+			return fmt.Errorf("workspace files couldn't be saved, keeping the workspace: %v", err)
+		}
+	}
+
+	//delete everything labelled with the workspace, waiting until it's gone:
+	deleteCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	err = srv.K8s.DeleteWorkspace(deleteCtx, workspaceId)
+	cancel()
+	if err != nil {
+		fmt.Println("Error deleting workspace resources:", err)
+		return err
+	}
+
+	//snapshot the files (pod is gone so they are consistent) before deleting the local cache:
+	snapshotted := false
+	if _, statErr := os.Stat(cacheDir); statErr == nil && saveFiles {
+		if _, err := aws.UploadSnapshot(ctx, workspaceId); err != nil {
+			fmt.Println("Error uploading snapshot:", err)
+			return err
+		}
+		snapshotted = true
+	}
+
+	//delete Local Cache
+	if err := os.RemoveAll(cacheDir); err != nil {
+		fmt.Println("Error deleting cache:", err)
+		return err
+	}
+	srv.Activity.Forget(workspaceId)
+
+	//keep the record of a snapshotted workspace so it can be reopened later:
+	if snapshotted && record != nil {
+		record.Resources = nil
+		record.State = workspace.StateStopped
+		record.UpdatedAt = time.Now()
+		return srv.Store.Put(record)
+	}
+
+	if err := srv.Store.Delete(workspaceId); err != nil {
+		fmt.Println("Error deleting workspace record:", err)
+		return err
+	}
+	return nil
+}
+
+// copyVolumeOut copies a volume backed workspace's files into cacheDir. A suspended
+// workspace has no pod, so its StatefulSet is scaled back up for the copy; a workspace
+// whose StatefulSet was never created has nothing to copy.
+func (srv *Server) copyVolumeOut(ctx context.Context, record *workspace.Record, cacheDir string) error {
+	status, ok := srv.Status.Get(record.ID)
+	if !ok || !status.Ready {
+		var statefulSet string
+		for _, res := range record.Resources {
+			if res.Kind == "StatefulSet" {
+				statefulSet = res.Name
+			}
+		}
+		if statefulSet == "" {
+			return nil
+		}
+
+		scale := []byte(`{"spec":{"replicas":1}}`)
+		_, err := srv.K8s.Clientset.AppsV1().StatefulSets(namespace).Patch(ctx, statefulSet, types.MergePatchType, scale, metav1.PatchOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error scaling up %s: %v", statefulSet, err)
+		}
+		if status, err = srv.Status.WaitReady(ctx, record.ID, podReadyTimeout, nil); err != nil {
+			return err
+		}
+	}
+	return k8s.NewPodFS(srv.K8s, status.Pod).CopyOut(ctx, cacheDir)
+}
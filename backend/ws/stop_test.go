@@ -0,0 +1,225 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mudit06mah/CloudIde/auth"
+	"github.com/mudit06mah/CloudIde/k8s"
+	"github.com/mudit06mah/CloudIde/workspace"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery/cached/memory"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// apiServer answers typed client requests with 404, except scaling a StatefulSet which
+// succeeds; it records every request it gets
+type apiServer struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (a *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	a.requests = append(a.requests, r.Method+" "+r.URL.Path)
+	a.mu.Unlock()
+
+	if r.Method == http.MethodPatch && strings.Contains(r.URL.Path, "/statefulsets/") {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"StatefulSet","apiVersion":"apps/v1","metadata":{"name":"shell-ws1"}}`))
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// newTestCluster returns a client whose dynamic and discovery clients are fakes holding
+// objects, and whose typed clientset talks to api. Manifests applied through it are stored.
+func newTestCluster(t *testing.T, api http.Handler, objects ...*appsv1.StatefulSet) *k8s.Client {
+	t.Helper()
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verbs := metav1.Verbs{"list", "patch", "delete"}
+	fakeDiscovery := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: []*metav1.APIResourceList{{
+		GroupVersion: "apps/v1",
+		APIResources: []metav1.APIResource{{Name: "statefulsets", Namespaced: true, Kind: "StatefulSet", Verbs: verbs}},
+	}, {
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "pods", Namespaced: true, Kind: "Pod", Verbs: verbs},
+			{Name: "services", Namespaced: true, Kind: "Service", Verbs: verbs},
+			{Name: "persistentvolumeclaims", Namespaced: true, Kind: "PersistentVolumeClaim", Verbs: verbs},
+		},
+	}, {
+		GroupVersion: "networking.k8s.io/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "ingresses", Namespaced: true, Kind: "Ingress", Verbs: verbs},
+			{Name: "networkpolicies", Namespaced: true, Kind: "NetworkPolicy", Verbs: verbs},
+		},
+	}}}}
+	cached := memory.NewMemCacheClient(fakeDiscovery)
+
+	var objs []runtime.Object
+	for _, obj := range objects {
+		objs = append(objs, obj)
+	}
+	dynamic := dynamicfake.NewSimpleDynamicClient(scheme.Scheme, objs...)
+	//the fake tracker can't server-side apply, so applies create or replace the object:
+	dynamic.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch, ok := action.(clienttesting.PatchAction)
+		if !ok || patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		err := dynamic.Tracker().Create(patch.GetResource(), obj, patch.GetNamespace())
+		if apierrors.IsAlreadyExists(err) {
+			err = dynamic.Tracker().Update(patch.GetResource(), obj, patch.GetNamespace())
+		}
+		return true, obj, err
+	})
+
+	return &k8s.Client{
+		Config:     &rest.Config{Host: server.URL},
+		Clientset:  clientset,
+		Dynamic:    dynamic,
+		RESTMapper: restmapper.NewDeferredDiscoveryRESTMapper(cached),
+		Discovery:  cached,
+	}
+}
+
+// TestStopFailedVolumeWorkspace stops a volume workspace whose pod never ran. Its files
+// mustn't be copied out (that waits for a pod that won't come up) nor snapshotted, and
+// the workspace must be deleted rather than kept.
+func TestStopFailedVolumeWorkspace(t *testing.T) {
+	t.Setenv("CACHE_DIR", t.TempDir())
+	t.Setenv("SNAPSHOTS_ENABLED", "true")
+	cacheDir := filepath.Join(os.Getenv("CACHE_DIR"), "ws1")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	api := &apiServer{}
+	statefulSet := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "shell-ws1", Namespace: k8s.Namespace, Labels: map[string]string{k8s.LabelWorkspace: "ws1"},
+	}}
+	srv := &Server{
+		Store:    workspace.NewMemoryStore(),
+		Activity: workspace.NewActivity(),
+		K8s:      newTestCluster(t, api, statefulSet),
+		Status:   k8s.NewStatusController(fake.NewSimpleClientset()),
+	}
+	record := &workspace.Record{
+		ID:        "ws1",
+		Owner:     "user-1",
+		Storage:   workspace.StorageVolume,
+		State:     workspace.StateFailed,
+		Resources: []workspace.Resource{{Kind: "StatefulSet", Name: "shell-ws1"}},
+	}
+	if err := srv.Store.Put(record); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.StopWorkspace(ctx, "ws1"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, request := range api.requests {
+		if strings.Contains(request, "/statefulsets/") {
+			t.Errorf("scaled the StatefulSet up to copy files: %s", request)
+		}
+	}
+	if _, err := srv.Store.Get("ws1"); err != workspace.ErrNotFound {
+		t.Errorf("record kept (%v)", err)
+	}
+	if _, err := os.Stat(cacheDir); !os.IsNotExist(err) {
+		t.Errorf("cache dir still present (%v)", err)
+	}
+	list, err := srv.K8s.Dynamic.Resource(appsv1.SchemeGroupVersion.WithResource("statefulsets")).Namespace(k8s.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 0 {
+		t.Errorf("StatefulSet not deleted: %v", list.Items)
+	}
+}
+
+// TestStopFailureRepliesOnce checks a stop that fails, here for want of a cluster, answers the
+// request with exactly one failed response carrying its id
+func TestStopFailureRepliesOnce(t *testing.T) {
+	authenticator := auth.NewHMACAuthenticator([]byte("test secret"))
+	srv := &Server{Auth: authenticator, Store: workspace.NewMemoryStore(), Activity: workspace.NewActivity()}
+	if err := srv.Store.Put(&workspace.Record{ID: "ws1", Owner: "user-1", State: workspace.StateRunning}); err != nil {
+		t.Fatal(err)
+	}
+	token, _ := authenticator.Sign("user-1", time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(srv.wsHandler))
+	defer server.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{ProtocolV2}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	msg, _ := json.Marshal(Message{ID: "stop-1", Type: "stopWorkspace", Payload: json.RawMessage(`{"workspaceId":"ws1"}`)})
+	if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	//a second reply follows the first at once, so a short quiet period after it is enough:
+	var replies []Response
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn.SetReadDeadline(deadline)
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		var resp Response
+		if err := json.Unmarshal(frame, &resp); err != nil || resp.ID != "stop-1" {
+			continue
+		}
+		replies = append(replies, resp)
+		deadline = time.Now().Add(200 * time.Millisecond)
+	}
+
+	if len(replies) != 1 {
+		t.Fatalf("got %d replies to the stop, want 1: %+v", len(replies), replies)
+	}
+	if replies[0].Success || replies[0].Type != "stopWorkspace" {
+		t.Errorf("reply = %+v, want a failed stopWorkspace", replies[0])
+	}
+	if _, err := srv.Store.Get("ws1"); err != nil {
+		t.Errorf("record of the workspace that failed to stop: %v", err)
+	}
+}
@@ -4,8 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"sync"
 	"os"
+	"sync"
 
 	"github.com/gorilla/websocket"
 	v1 "k8s.io/api/core/v1"
@@ -23,12 +23,15 @@ type TerminalMessage struct {
 }
 
 type TerminalSession struct {
-	ws       *websocket.Conn
+	ws       *Conn
 	sizeChan chan remotecommand.TerminalSize
 	doneChan chan struct{}
 
 	readBuf []byte
 	readMu  sync.Mutex
+
+	// onInput is called for every stdin message (activity tracking)
+	onInput func()
 }
 
 func (t *TerminalSession) Next() *remotecommand.TerminalSize {
@@ -53,6 +56,9 @@ func (t *TerminalSession) Read(p []byte) (int, error) {
 
 	switch msg.Op {
 	case "stdin":
+		if t.onInput != nil {
+			t.onInput()
+		}
 		return copy(p, []byte(msg.Data)), nil
 	case "resize":
 		t.sizeChan <- remotecommand.TerminalSize{Width: msg.Cols, Height: msg.Rows}
@@ -63,24 +69,31 @@ func (t *TerminalSession) Read(p []byte) (int, error) {
 }
 
 func (t *TerminalSession) Write(p []byte) (int, error) {
-	err := t.ws.WriteMessage(websocket.TextMessage, p)
-	return len(p), err
+	//the exec stream reuses p, and the frame is written later by the writer goroutine:
+	msg := make([]byte, len(p))
+	copy(msg, p)
+	if err := t.ws.Write(msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
-func HandleTerminal(w http.ResponseWriter, r *http.Request, client *kubernetes.Clientset, config *rest.Config, podname string) {
+func HandleTerminal(w http.ResponseWriter, r *http.Request, client *kubernetes.Clientset, config *rest.Config, podname string, onInput func()) {
 	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
+		CheckOrigin: checkOrigin,
 	}
-	conn, err := upgrader.Upgrade(w, r, nil)
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return
 	}
+	conn := NewConn(ws)
 	defer conn.Close()
 
 	session := &TerminalSession{
 		ws:       conn,
 		sizeChan: make(chan remotecommand.TerminalSize),
 		doneChan: make(chan struct{}),
+		onInput:  onInput,
 	}
 
 	req := client.CoreV1().RESTClient().Post().
@@ -3,6 +3,7 @@ import { Terminal } from 'xterm';
 import { FitAddon } from 'xterm-addon-fit';
 import 'xterm/css/xterm.css';
 import { AttachAddon } from 'xterm-addon-attach';
+import { authToken } from '../../utils/Socket';
 
 interface XtermProps {
     workspaceId: string;
@@ -37,7 +38,7 @@ const Xterm = ({ workspaceId }: XtermProps) => {
         // 2. Connect to WebSocket
         // FIX: Added 'workspaceId' query param so backend can initialize the K8s Client
         const socket = new WebSocket(
-            `ws://localhost:8080/ws?type=terminal&pod=shell-${workspaceId}&workspaceId=${workspaceId}`
+            `ws://localhost:8080/ws?type=terminal&workspaceId=${workspaceId}&token=${encodeURIComponent(authToken)}`
         );
         
         socket.onopen = () => {
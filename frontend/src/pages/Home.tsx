@@ -1,36 +1,85 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import { useNavigate } from 'react-router-dom';
 import { useSocket } from '../utils/Socket';
 
+interface ProjectType {
+    name: string;
+    displayName: string;
+    plans?: string[];
+}
+
+interface Plan {
+    name: string;
+    displayName?: string;
+}
+
+// Used until the backend answers listProjectTypes
+const defaultProjectTypes: ProjectType[] = [
+    { name: "cpp", displayName: "C++" },
+    { name: "python", displayName: "Python" },
+    { name: "golang", displayName: "Golang" },
+    { name: "nodejs", displayName: "NodeJS" },
+    { name: "react", displayName: "React" },
+];
+
 export default function Home() {
     const [loading, setLoading] = useState(false);
+    const [progress, setProgress] = useState<{ stage: string; message?: string } | null>(null);
+    const [projectTypes, setProjectTypes] = useState<ProjectType[]>(defaultProjectTypes);
+    const [plans, setPlans] = useState<Plan[]>([]);
+    const [selectedType, setSelectedType] = useState<string>("");
     const navigate = useNavigate();
-    const { sendMessage, subscribe } = useSocket();
+    const { socket, sendMessage, subscribe } = useSocket();
+
+    useEffect(() => {
+        const unsubscribe = subscribe("Project types retrieved successfully", (payload: any) => {
+            if (payload?.projectTypes?.length) {
+                setProjectTypes(payload.projectTypes);
+            }
+            setPlans(payload?.plans ?? []);
+        });
+        sendMessage("listProjectTypes", {});
+        return unsubscribe;
+    }, [socket]);
+
+    // plans offered for the selected type, in the order the backend lists them (default first)
+    const typePlans = projectTypes.find(({ name }) => name === selectedType)?.plans;
+    const availablePlans = typePlans
+        ? typePlans.map((name) => plans.find((plan) => plan.name === name) ?? { name })
+        : plans;
 
     const handleFormSubmit = (event: React.FormEvent<HTMLFormElement>) => {
         event.preventDefault();
         const formData = new FormData(event.currentTarget);
         const projectType = formData.get("option") as string;
+        const plan = (formData.get("plan") as string) || undefined;
 
         if (!projectType) return;
 
         setLoading(true);
-        
+        setProgress(null);
+
+        // provisioning stages streamed until the project is created or fails
+        const unsubscribeProgress = subscribe("Workspace progress", (payload: any) => {
+            setProgress(payload);
+            if (payload.stage === "failed") {
+                setLoading(false);
+                unsubscribeProgress();
+                unsubscribe();
+            }
+        });
+
         // Subscribe to success response
         const unsubscribe = subscribe("Project created successfully", (payload: any) => {
             setLoading(false);
+            setProgress(null);
             unsubscribe();
+            unsubscribeProgress();
             console.log(payload)
             navigate(`/workspace/${payload.workspaceId}`, { state: { tree: payload.fileNode }});
         });
 
-        const typeMap: Record<string, string> = {
-            "1": "cpp", "2": "python", "3": "golang", "4": "nodejs", "5":"react"
-        };
-        
-        sendMessage("initProject", {
-            projectType: typeMap[projectType] || "python"
-        });
+        sendMessage("initProject", { projectType, plan });
     };
 
     return (
@@ -40,15 +89,22 @@ export default function Home() {
                 <form className="space-y-6" onSubmit={handleFormSubmit}>
                     <h2 className="text-xl font-semibold mb-4">Select Template</h2>
                     <div className="grid grid-cols-2 gap-4">
-                        {["C++", "Python", "Golang", "NodeJS", "React"].map((lang, idx) => (
-                            <label key={lang} className="cursor-pointer">
-                                <input type="radio" name="option" value={idx + 1} className="peer sr-only" />
+                        {projectTypes.map(({ name, displayName }) => (
+                            <label key={name} className="cursor-pointer">
+                                <input type="radio" name="option" value={name} className="peer sr-only" onChange={() => setSelectedType(name)} />
                                 <div className="p-4 rounded-lg border border-slate-700 bg-slate-800 hover:bg-slate-700 peer-checked:border-blue-500 peer-checked:bg-blue-500/10 transition-all text-center">
-                                    {lang}
+                                    {displayName}
                                 </div>
                             </label>
                         ))}
                     </div>
+                    {availablePlans.length > 0 && (
+                        <select name="plan" key={selectedType} className="w-full p-3 rounded-lg border border-slate-700 bg-slate-800">
+                            {availablePlans.map(({ name, displayName }) => (
+                                <option key={name} value={name}>{displayName || name}</option>
+                            ))}
+                        </select>
+                    )}
                     <button 
                         type="submit" 
                         disabled={loading}
@@ -56,6 +112,12 @@ export default function Home() {
                     >
                         {loading ? "Creating Environment..." : "Create Workspace"}
                     </button>
+                    {progress && (
+                        <div className={`text-sm ${progress.stage === "failed" ? "text-red-400" : "text-slate-400"}`}>
+                            <span className="font-mono">{progress.stage}</span>
+                            {progress.message && <span className="block break-words">{progress.message}</span>}
+                        </div>
+                    )}
                 </form>
             </div>
         </div>
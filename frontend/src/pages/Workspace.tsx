@@ -14,6 +14,16 @@ interface FileNode {
     path: string;
 }
 
+interface PodStatus {
+    pod: string;
+    phase: string;
+    ready: boolean;
+    restarts: number;
+    reason?: string;
+    message?: string;
+    deleted?: boolean;
+}
+
 export default function Workspace() {
     const { workspaceId } = useParams();
     const location = useLocation();
@@ -28,6 +38,7 @@ export default function Workspace() {
     const [selectedFile, setSelectedFile] = useState<{ path: string, content: string } | null>(null);
     const [selectedFolder, setSelectedFolder] = useState<string | null>(null);
     const [creatingConfig, setCreatingConfig] = useState<{ parentPath: string; type: "file" | "folder" } | null>(null);
+    const [podStatus, setPodStatus] = useState<PodStatus | null>(null);
 
     // Note: If you are using port 8080 for ingress, add :8080 to the end here.
     const previewUrl = `http://${workspaceId}-preview.127.0.0.1.nip.io`;
@@ -56,6 +67,25 @@ export default function Workspace() {
         return unsubscribe;
     }, [subscribe, selectedFolder]);
 
+    // files changed by the terminal or another tab: refresh the tree once things settle
+    useEffect(() => {
+        let timer: ReturnType<typeof setTimeout> | undefined;
+        const unsubscribe = subscribe("File changed", (payload: any) => {
+            if (payload.op === "write") return;
+            clearTimeout(timer);
+            timer = setTimeout(() => sendMessage("getTree", { workspaceId }), 300);
+        });
+        return () => {
+            clearTimeout(timer);
+            unsubscribe();
+        };
+    }, [subscribe, sendMessage, workspaceId]);
+
+    // pod status pushed by the backend whenever it changes
+    useEffect(() => {
+        return subscribe("Workspace status", (payload: any) => setPodStatus(payload as PodStatus));
+    }, [subscribe]);
+
     const handleNodeSelect = (node: FileNode) => {
         if (node.type === "file") {
             const unsubscribe = subscribe("File retrieved successfully", (payload: any) => {
@@ -113,6 +143,14 @@ export default function Workspace() {
             <div className="h-10 border-b border-slate-800 flex items-center px-4 bg-slate-900 justify-between">
                 <div className="flex items-center gap-2">
                     <span className="font-mono text-sm text-slate-400">workspace: <span className="text-blue-400">{workspaceId}</span></span>
+                    {podStatus && (
+                        <span
+                            className={`text-xs px-2 py-0.5 rounded ${podStatus.ready ? "bg-green-900 text-green-300" : "bg-amber-900 text-amber-300"}`}
+                            title={podStatus.message || `${podStatus.restarts} restarts`}
+                        >
+                            {podStatus.deleted ? "Stopped" : podStatus.ready ? "Running" : podStatus.reason || podStatus.phase}
+                        </span>
+                    )}
                 </div>
 
                 <div className="flex items-center gap-3">
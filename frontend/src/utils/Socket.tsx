@@ -2,13 +2,21 @@ import { createContext, useContext, useEffect, useState, useRef, type PropsWithC
 
 interface SocketContextType {
     socket: WebSocket | null;
-    sendMessage: (type: string, payload: any) => void;
+    // Sends a request and returns its id, which the backend echoes on the response
+    sendMessage: (type: string, payload: any) => string;
     // Subscribe to messages. Returns an unsubscribe function.
     subscribe: (event: string, callback: (payload: any) => void) => () => void;
 }
 
 const WsContext = createContext<SocketContextType | null>(null);
 
+// Bearer token checked by the backend before the websocket upgrade
+export const authToken: string = import.meta.env.VITE_AUTH_TOKEN ?? "";
+
+// Protocol versions offered to the backend, newest first. In v2 server-pushed data
+// (terminal output, file changes, workspace status/progress) arrives as event frames.
+const protocols = ["cloudide.v2", "cloudide.v1"];
+
 export const useSocket = () => {
     const context = useContext(WsContext);
     if (!context) {
@@ -22,7 +30,7 @@ export function SocketProvider({ children }: PropsWithChildren<{}>) {
     const listeners = useRef<Map<string, Set<(payload: any) => void>>>(new Map());
 
     useEffect(() => {
-        const ws = new WebSocket("ws://localhost:8080/ws"); // Ensure port matches your backend
+        const ws = new WebSocket(`ws://localhost:8080/ws?token=${encodeURIComponent(authToken)}`, protocols); // Ensure port matches your backend
 
         ws.onopen = () => {
             console.log("Connected to WS Server");
@@ -32,9 +40,9 @@ export function SocketProvider({ children }: PropsWithChildren<{}>) {
         ws.onmessage = (event) => {
             try {
                 const response = JSON.parse(event.data);
-                // Backend sends: { success: bool, message: string, payload: any }
-                // We use the 'message' field as the event type trigger
-                const eventType = response.message; 
+                // Responses: { id?, type, success, message, payload }, keyed by 'message'
+                // Events: { event, id?, payload }, keyed by 'event'
+                const eventType = response.event ?? response.message;
                 
                 if (listeners.current.has(eventType)) {
                     listeners.current.get(eventType)?.forEach((cb) => cb(response.payload));
@@ -54,12 +62,16 @@ export function SocketProvider({ children }: PropsWithChildren<{}>) {
         };
     }, []);
 
+    const nextId = useRef(0);
+
     const sendMessage = (type: string, payload: any) => {
+        const id = String(++nextId.current);
         if (socket && socket.readyState === WebSocket.OPEN) {
-            socket.send(JSON.stringify({ type, payload }));
+            socket.send(JSON.stringify({ id, type, payload }));
         } else {
             console.warn("Socket not connected");
         }
+        return id;
     };
 
     const subscribe = (event: string, callback: (payload: any) => void) => {